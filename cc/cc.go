@@ -2,7 +2,9 @@ package cc
 
 import (
 	"fmt"
+	"log"
 	"strconv"
+	"time"
 
 	"github.com/huin/gocc"
 	"github.com/huin/warren/util"
@@ -14,9 +16,29 @@ const (
 )
 
 type Config struct {
+	// Serial device to read from, "tcp://host:port" or "rfc2217://host:port"
+	// for a serial-to-network bridge, or "replay:" followed by the path of a
+	// capture file to replay.
 	Device string
 	Labels promm.Labels
 	Sensor map[string]SensorConfig
+	// Timeout for connecting to a network device. Defaults to 10 seconds.
+	ConnectTimeout util.Duration `toml:"connect_timeout"`
+	// Period of TCP keepalives to a network device, which detect a bridge
+	// that has gone away. Defaults to 30 seconds.
+	KeepAlive util.Duration `toml:"keepalive"`
+	// Readings further apart than this are not integrated into energy totals.
+	// Defaults to 30 seconds.
+	MaxGap util.Duration `toml:"max_gap"`
+	// File to persist energy totals in across restarts, if set.
+	EnergyStateFile string `toml:"energy_state_file"`
+	// File to append every received message to, as the raw XML with its
+	// receive time, if set. The file can later be replayed with a "replay:"
+	// Device.
+	CapturePath string `toml:"capture_path"`
+	// Speed multiplier when replaying a capture file, e.g 60 replays an hour
+	// of messages in a minute. Defaults to 1 (real time).
+	ReplaySpeed float64 `toml:"replay_speed"`
 }
 
 type SensorConfig struct {
@@ -28,12 +50,16 @@ type Collector struct {
 	sensorCfgs      map[int]SensorConfig
 	histSensorsSeen map[int]struct{}
 	lastSeenDsb     int
+	// Device opened by Open, reused by every call to Run.
+	msgReader       messageSource
 	metrics         util.MetricCollection
 	realtimeUpdates *promm.CounterVec
 	historyUpdates  promm.Counter
 	temperature     promm.Gauge
 	powerDraw       *promm.GaugeVec
 	powerUsage      *promm.GaugeVec
+	energy          *energyIntegrator
+	lastEnergySave  time.Time
 }
 
 func New(cfg Config) (*Collector, error) {
@@ -90,6 +116,21 @@ func New(cfg Config) (*Collector, error) {
 			[]string{"sensor"},
 		),
 	}
+	c.energy = newEnergyIntegrator(cfg.MaxGap.Duration, metrics.NewCounterVec(
+		promm.CounterOpts{
+			Namespace: namespace, Name: "energy_joules_total",
+			Help: "Cumulative energy used, measured by sensor and channel. This is " +
+				"integrated from realtime power readings, omitting gaps between " +
+				"readings longer than max_gap. (joules)",
+			ConstLabels: cfg.Labels,
+		},
+		[]string{"sensor", "channel"},
+	))
+	if cfg.EnergyStateFile != "" {
+		if err := c.energy.load(cfg.EnergyStateFile); err != nil {
+			return nil, fmt.Errorf("loading energy state: %v", err)
+		}
+	}
 	c.metrics = metrics
 	return c, nil
 }
@@ -102,7 +143,7 @@ func (c *Collector) Collect(ch chan<- promm.Metric) {
 	c.metrics.Collect(ch)
 }
 
-func (c *Collector) powerDrawReading(sensorName string, channel int, reading *gocc.Channel) {
+func (c *Collector) powerDrawReading(sensorName string, channel int, reading *gocc.Channel, t time.Time) {
 	if reading == nil {
 		return
 	}
@@ -111,6 +152,34 @@ func (c *Collector) powerDrawReading(sensorName string, channel int, reading *go
 		"channel": strconv.Itoa(channel),
 	},
 	).Set(float64(reading.Watts))
+	c.energy.reading(energyKey{sensor: sensorName, channel: channel}, t, float64(reading.Watts))
+}
+
+// Saves energy totals to the state file, if configured and not done within
+// energySaveInterval.
+func (c *Collector) maybeSaveEnergy(t time.Time) {
+	if c.cfg.EnergyStateFile == "" || t.Sub(c.lastEnergySave) < energySaveInterval {
+		return
+	}
+	c.lastEnergySave = t
+	if err := c.energy.save(c.cfg.EnergyStateFile); err != nil {
+		log.Printf("Error saving CurrentCost energy state: %v", err)
+	}
+}
+
+// Opens the CurrentCost device ahead of calling Run. The device then remains
+// open, and is used by all calls to Run rather than reopening it. This allows
+// the device to be opened before dropping privileges. After a read error,
+// reading resumes from the next message on the open device. Network devices
+// are connected to on the first call to Run, and reconnected to by later calls
+// after an error.
+func (c *Collector) Open() error {
+	msgReader, err := c.openSource()
+	if err != nil {
+		return err
+	}
+	c.msgReader = msgReader
+	return nil
 }
 
 // Runs the collector such that it receives updates from the CurrentCost device
@@ -118,14 +187,18 @@ func (c *Collector) powerDrawReading(sensorName string, channel int, reading *go
 // although some errors might reccur. E.g the device might not exist. This
 // could be a permanent or temporary condition.
 func (c *Collector) Run() error {
-	msgReader, err := gocc.NewSerialMessageReader(c.cfg.Device)
-	if err != nil {
-		return err
+	msgReader := c.msgReader
+	if msgReader == nil {
+		var err error
+		msgReader, err = c.openSource()
+		if err != nil {
+			return err
+		}
+		defer msgReader.Close()
 	}
-	defer msgReader.Close()
 
 	for {
-		msg, err := msgReader.ReadMessage()
+		msg, t, err := msgReader.readMessage()
 		if err != nil {
 			return err
 		}
@@ -139,13 +212,17 @@ func (c *Collector) Run() error {
 		c.lastSeenDsb = msg.DaysSinceBirth
 
 		if msg.History == nil {
-			c.processRealtimeData(msg)
+			c.processRealtimeData(msg, t)
 		} else {
 			c.processHistoricalData(msg)
 		}
 	}
 }
 
+func (c *Collector) openSource() (messageSource, error) {
+	return openSource(c.cfg)
+}
+
 func (c *Collector) sensorName(sensor int) string {
 	sensorCfg, ok := c.sensorCfgs[sensor]
 	if !ok {
@@ -154,7 +231,8 @@ func (c *Collector) sensorName(sensor int) string {
 	return sensorCfg.Name
 }
 
-func (c *Collector) processRealtimeData(msg *gocc.Message) {
+// t is the time that the message was received.
+func (c *Collector) processRealtimeData(msg *gocc.Message, t time.Time) {
 	if msg.Sensor == nil || *msg.Sensor < 0 {
 		return
 	}
@@ -166,9 +244,10 @@ func (c *Collector) processRealtimeData(msg *gocc.Message) {
 		c.temperature.Set(float64(*msg.Temperature))
 	}
 
-	c.powerDrawReading(sensorName, 1, msg.Channel1)
-	c.powerDrawReading(sensorName, 2, msg.Channel2)
-	c.powerDrawReading(sensorName, 3, msg.Channel3)
+	c.powerDrawReading(sensorName, 1, msg.Channel1, t)
+	c.powerDrawReading(sensorName, 2, msg.Channel2, t)
+	c.powerDrawReading(sensorName, 3, msg.Channel3, t)
+	c.maybeSaveEnergy(t)
 }
 
 // Produce cumulative power usage by accumulating most recent two-hourly data
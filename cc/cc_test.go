@@ -0,0 +1,228 @@
+package cc
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	promm "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Capture of a monitor that restarts part way through, so that its
+// DaysSinceBirth drops from 10 to 0.
+const testCapturePath = "testdata/capture.txt"
+
+// Fast enough that replaying the capture takes under a millisecond.
+const testReplaySpeed = 1e6
+
+// Gathers the metrics exported by c through a registry, and returns them as
+// sorted "type name{labels} value" strings.
+func gatherLines(t *testing.T, c promm.Collector) []string {
+	t.Helper()
+	reg := promm.NewPedanticRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	var lines []string
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			lines = append(lines, formatMetric(mf, m))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func formatMetric(mf *dto.MetricFamily, m *dto.Metric) string {
+	var labels []string
+	for _, lp := range m.Label {
+		labels = append(labels, fmt.Sprintf("%s=%q", lp.GetName(), lp.GetValue()))
+	}
+	var value float64
+	switch mf.GetType() {
+	case dto.MetricType_GAUGE:
+		value = m.GetGauge().GetValue()
+	case dto.MetricType_COUNTER:
+		value = m.GetCounter().GetValue()
+	}
+	return fmt.Sprintf("%s %s{%s} %.15g", strings.ToLower(mf.GetType().String()),
+		mf.GetName(), strings.Join(labels, ","), value)
+}
+
+func checkLines(t *testing.T, got, want []string) {
+	t.Helper()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got metrics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestReplay(t *testing.T) {
+	c, err := New(Config{
+		Device:      replayDevicePrefix + testCapturePath,
+		ReplaySpeed: testReplaySpeed,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := c.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer c.msgReader.Close()
+
+	if err := c.Run(); err != io.EOF {
+		t.Fatalf("Run error = %v, want EOF", err)
+	}
+	// The 2-hourly history of 1.5 and 0.75 kWh is discarded when
+	// DaysSinceBirth drops, leaving only the history received after it. The
+	// energy between the last two realtime readings is not integrated, as they
+	// are over max_gap apart.
+	checkLines(t, gatherLines(t, c), []string{
+		`counter currentcost_energy_joules_total{channel="1",sensor="0"} 3600`,
+		`counter currentcost_history_count{} 3`,
+		`counter currentcost_realtime_by_sensor_count{sensor="0"} 3`,
+		`gauge currentcost_power_draw_watts{channel="1",sensor="0"} 300`,
+		`gauge currentcost_power_usage_kwhr{sensor="0"} 0.5`,
+		`gauge currentcost_temperature_degc{} 20`,
+	})
+
+	// The replay starts again from the beginning, rather than returning EOF
+	// for the rest of the source's life.
+	if err := c.Run(); err != io.EOF {
+		t.Fatalf("second Run error = %v, want EOF", err)
+	}
+	checkLines(t, gatherLines(t, c), []string{
+		`counter currentcost_energy_joules_total{channel="1",sensor="0"} 7200`,
+		`counter currentcost_history_count{} 6`,
+		`counter currentcost_realtime_by_sensor_count{sensor="0"} 6`,
+		`gauge currentcost_power_draw_watts{channel="1",sensor="0"} 300`,
+		`gauge currentcost_power_usage_kwhr{sensor="0"} 0.5`,
+		`gauge currentcost_temperature_degc{} 20`,
+	})
+}
+
+const (
+	testMessage1 = "<msg><src>CC128-v0.11</src><dsb>00010</dsb><time>12:00:00</time><tmpr>21.5</tmpr><sensor>0</sensor><id>01234</id><type>1</type><ch1><watts>00500</watts></ch1></msg>\r\n"
+	testMessage2 = "<msg><src>CC128-v0.11</src><dsb>00010</dsb><time>12:00:06</time><tmpr>21.5</tmpr><sensor>1</sensor><id>01235</id><type>1</type><ch1><watts>00700</watts></ch1></msg>\r\n"
+)
+
+// Listens for connections from a netSource, handling each with the next of
+// handlers. The listener is closed once all handlers have been used.
+func serveTest(t *testing.T, handlers ...func(net.Conn)) (addr string, done <-chan struct{}) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		defer l.Close()
+		for _, handler := range handlers {
+			conn, err := l.Accept()
+			if err != nil {
+				t.Errorf("Accept: %v", err)
+				return
+			}
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			handler(conn)
+			conn.Close()
+		}
+	}()
+	return l.Addr().String(), doneCh
+}
+
+func writeString(conn net.Conn, s string) {
+	conn.Write([]byte(s))
+}
+
+// Reads the next message from src, and checks that it is for sensor.
+func expectSourceMessage(t *testing.T, src messageSource, sensor int) {
+	t.Helper()
+	msg, _, err := src.readMessage()
+	if err != nil {
+		t.Fatalf("readMessage: %v", err)
+	}
+	if msg.Sensor == nil || *msg.Sensor != sensor {
+		t.Fatalf("readMessage got message %+v, want one for sensor %d", msg, sensor)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	// Elements that gocc does not decode are captured as received.
+	unknown := strings.Replace(testMessage2, "</msg>", "<unknown>1</unknown></msg>", 1)
+	broken := "<msg><src>CC128-v0.11</msg>\r\n"
+	addr, done := serveTest(t,
+		// Sent together, so that the decoder reads ahead into the second.
+		func(conn net.Conn) { writeString(conn, testMessage1+unknown) },
+		func(conn net.Conn) { writeString(conn, broken) },
+	)
+	path := filepath.Join(t.TempDir(), "capture.txt")
+	src, err := openSource(Config{Device: tcpDevicePrefix + addr, CapturePath: path})
+	if err != nil {
+		t.Fatalf("openSource: %v", err)
+	}
+	expectSourceMessage(t, src, 0)
+	expectSourceMessage(t, src, 1)
+	// The connection is closed after the first two messages.
+	if _, _, err := src.readMessage(); err == nil {
+		t.Fatal("readMessage succeeded after the connection was closed")
+	}
+	if _, _, err := src.readMessage(); err == nil {
+		t.Fatal("readMessage succeeded for a broken message")
+	}
+	<-done
+	if err := src.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		space := strings.IndexByte(line, ' ')
+		if space < 0 {
+			t.Fatalf("malformed capture line %q", line)
+		}
+		if _, err := time.Parse(captureTimeLayout, line[:space]); err != nil {
+			t.Errorf("capture line %q has no receive time: %v", line, err)
+		}
+		got = append(got, line[space+1:])
+	}
+	want := []string{
+		strings.TrimSpace(testMessage1),
+		strings.TrimSpace(unknown),
+		strings.TrimSpace(broken),
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got capture:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Replaying the capture gives the same messages, and fails on the same
+	// data.
+	replay, err := openSource(Config{Device: replayDevicePrefix + path, ReplaySpeed: testReplaySpeed})
+	if err != nil {
+		t.Fatalf("openSource: %v", err)
+	}
+	defer replay.Close()
+	expectSourceMessage(t, replay, 0)
+	expectSourceMessage(t, replay, 1)
+	if _, _, err := replay.readMessage(); err == nil || err == io.EOF {
+		t.Fatalf("replay of broken message got error %v, want a decode error", err)
+	}
+	if _, _, err := replay.readMessage(); err != io.EOF {
+		t.Fatalf("replay error = %v, want EOF", err)
+	}
+}
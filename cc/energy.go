@@ -0,0 +1,158 @@
+package cc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Realtime readings arrive roughly every 6 seconds, so a longer gap means
+	// that readings were missed.
+	defaultMaxGap = 30 * time.Second
+	// How often the energy totals are saved to Config.EnergyStateFile.
+	energySaveInterval = time.Minute
+)
+
+type energyKey struct {
+	sensor  string
+	channel int
+}
+
+type energyReading struct {
+	time  time.Time
+	watts float64
+}
+
+// Integrates realtime power readings into energy totals.
+type energyIntegrator struct {
+	maxGap time.Duration
+	// Most recent reading, by sensor and channel.
+	last map[energyKey]energyReading
+	// Energy totals, by sensor and channel (joules). These are the values of
+	// counter, kept for persistence.
+	totals  map[energyKey]float64
+	counter *promm.CounterVec
+}
+
+func newEnergyIntegrator(maxGap time.Duration, counter *promm.CounterVec) *energyIntegrator {
+	if maxGap == 0 {
+		maxGap = defaultMaxGap
+	}
+	return &energyIntegrator{
+		maxGap:  maxGap,
+		last:    make(map[energyKey]energyReading),
+		totals:  make(map[energyKey]float64),
+		counter: counter,
+	}
+}
+
+func (e *energyIntegrator) add(key energyKey, joules float64) {
+	e.totals[key] += joules
+	e.counter.With(promm.Labels{
+		"sensor":  key.sensor,
+		"channel": strconv.Itoa(key.channel),
+	}).Add(joules)
+}
+
+// Adds the energy used since the previous reading for the same sensor and
+// channel, assuming that power changed linearly between them. Nothing is added
+// for the first reading, or if the readings are more than maxGap apart, as the
+// power in between is unknown.
+func (e *energyIntegrator) reading(key energyKey, t time.Time, watts float64) {
+	prev, ok := e.last[key]
+	e.last[key] = energyReading{time: t, watts: watts}
+	if !ok {
+		// Ensure that the counter is exported from the first reading.
+		e.add(key, 0)
+		return
+	}
+	dt := t.Sub(prev.time)
+	if dt <= 0 || dt > e.maxGap {
+		return
+	}
+	e.add(key, (prev.watts+watts)/2*dt.Seconds())
+}
+
+// Energy totals as saved to a state file: joules by sensor name, then by
+// channel number.
+type energyState map[string]map[string]float64
+
+// Loads energy totals saved by save. A missing file is not an error, as it
+// will not yet exist when first configured. Nothing is loaded if any total is
+// invalid, as counters cannot be negative.
+func (e *energyIntegrator) load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var state energyState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	totals := make(map[energyKey]float64)
+	for sensor, channels := range state {
+		for channelStr, joules := range channels {
+			channel, err := strconv.Atoi(channelStr)
+			if err != nil {
+				continue
+			}
+			if joules < 0 || math.IsNaN(joules) || math.IsInf(joules, 0) {
+				return fmt.Errorf("invalid energy total %v for sensor %q channel %d", joules, sensor, channel)
+			}
+			totals[energyKey{sensor: sensor, channel: channel}] = joules
+		}
+	}
+	for key, joules := range totals {
+		e.add(key, joules)
+	}
+	return nil
+}
+
+// Saves the energy totals, replacing the file atomically so that a crash
+// cannot leave it partly written.
+func (e *energyIntegrator) save(path string) error {
+	state := energyState{}
+	for key, joules := range e.totals {
+		channels, ok := state[key.sensor]
+		if !ok {
+			channels = map[string]float64{}
+			state[key.sensor] = channels
+		}
+		channels[strconv.Itoa(key.channel)] = joules
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	// Ensure that the data is on disk before the rename, or a crash could leave
+	// an empty file in place of the previous one.
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
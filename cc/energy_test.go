@@ -0,0 +1,71 @@
+package cc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+func newTestEnergyIntegrator() *energyIntegrator {
+	return newEnergyIntegrator(0, promm.NewCounterVec(
+		promm.CounterOpts{Name: "energy_joules_total", Help: "Energy."},
+		[]string{"sensor", "channel"},
+	))
+}
+
+func TestEnergySaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "energy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "energy.json")
+
+	e := newTestEnergyIntegrator()
+	start := time.Unix(1500000000, 0)
+	key := energyKey{sensor: "0", channel: 1}
+	e.reading(key, start, 100)
+	e.reading(key, start.Add(6*time.Second), 200)
+	if err := e.save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	loaded := newTestEnergyIntegrator()
+	if err := loaded.load(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got, want := loaded.totals[key], 900.0; got != want {
+		t.Errorf("loaded total = %v, want %v", got, want)
+	}
+}
+
+func TestEnergyLoadInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "energy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "energy.json")
+	if err := ioutil.WriteFile(path, []byte(`{"0": {"1": 50, "2": -10}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEnergyIntegrator()
+	if err := e.load(path); err == nil {
+		t.Fatal("load succeeded with a negative total")
+	}
+	if len(e.totals) != 0 {
+		t.Errorf("load added totals %v despite failing", e.totals)
+	}
+}
+
+func TestEnergyLoadMissing(t *testing.T) {
+	e := newTestEnergyIntegrator()
+	if err := e.load(filepath.Join(os.TempDir(), "warren-energy-does-not-exist.json")); err != nil {
+		t.Errorf("load of missing file: %v", err)
+	}
+}
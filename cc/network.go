@@ -0,0 +1,246 @@
+package cc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/huin/gocc"
+)
+
+const (
+	// Prefixes of Config.Device that select network devices.
+	tcpDevicePrefix     = "tcp://"
+	rfc2217DevicePrefix = "rfc2217://"
+	netDefaultTimeout   = 10 * time.Second
+	netDefaultKeepAlive = 30 * time.Second
+	// Time to wait for each message before assuming that the bridge or device
+	// has gone away. Messages are sent every 6 seconds, so this allows for
+	// several to be lost.
+	netReadTimeout = 30 * time.Second
+	// Serial settings of CurrentCost devices (57600 baud 8N1), requested from
+	// RFC 2217 servers.
+	ccBaudRate = 57600
+	ccDataSize = 8
+)
+
+// Telnet (RFC 854) and COM-PORT-OPTION (RFC 2217) codes.
+const (
+	telnetSE        = 240
+	telnetSB        = 250
+	telnetWill      = 251
+	telnetWont      = 252
+	telnetDo        = 253
+	telnetDont      = 254
+	telnetIAC       = 255
+	telnetBinaryOpt = 0
+
+	comPortOption  = 44
+	comSetBaudRate = 1
+	comSetDataSize = 2
+	comSetParity   = 3
+	comSetStopSize = 4
+	comParityNone  = 1
+	comStopSizeOne = 1
+)
+
+// Reads messages from a CurrentCost device behind a serial-to-network bridge
+// (e.g ser2net). The connection is made on the first read, and after any
+// error, so that a Run that fails due to a dropped connection reconnects when
+// it is re-run. A connection that stays silent for longer than readTimeout is
+// treated as dropped, as keepalives do not detect a bridge that is up but has
+// lost its device.
+type netSource struct {
+	addr        string
+	rfc2217     bool
+	dialer      net.Dialer
+	readTimeout time.Duration
+	raw         io.Writer
+	conn        net.Conn
+	reader      *gocc.MessageReader
+}
+
+func newNetSource(addr string, rfc2217 bool, cfg Config, raw io.Writer) *netSource {
+	s := &netSource{
+		addr:    addr,
+		rfc2217: rfc2217,
+		dialer: net.Dialer{
+			Timeout:   cfg.ConnectTimeout.Duration,
+			KeepAlive: cfg.KeepAlive.Duration,
+		},
+		readTimeout: netReadTimeout,
+		raw:         raw,
+	}
+	if s.dialer.Timeout == 0 {
+		s.dialer.Timeout = netDefaultTimeout
+	}
+	if s.dialer.KeepAlive == 0 {
+		s.dialer.KeepAlive = netDefaultKeepAlive
+	}
+	return s
+}
+
+func (s *netSource) connect() error {
+	conn, err := s.dialer.Dial("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	var r io.Reader = conn
+	if s.rfc2217 {
+		if err := writeRfc2217Setup(conn); err != nil {
+			conn.Close()
+			return fmt.Errorf("configuring RFC 2217 port: %v", err)
+		}
+		r = &telnetReader{r: bufio.NewReader(conn), w: conn}
+	}
+	s.conn = conn
+	// Only the serial data is captured, not the telnet commands.
+	s.reader = gocc.NewMessageReader(teeRaw(r, s.raw))
+	return nil
+}
+
+func (s *netSource) readMessage() (*gocc.Message, time.Time, error) {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	if err := s.conn.SetReadDeadline(time.Now().Add(s.readTimeout)); err != nil {
+		s.Close()
+		return nil, time.Time{}, err
+	}
+	msg, err := s.reader.ReadMessage()
+	if err != nil {
+		s.Close()
+		return nil, time.Time{}, err
+	}
+	return msg, time.Now(), nil
+}
+
+func (s *netSource) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	s.reader = nil
+	return err
+}
+
+// Offers COM-PORT-OPTION and binary transmission, and requests the
+// CurrentCost serial settings.
+func writeRfc2217Setup(w io.Writer) error {
+	buf := []byte{
+		telnetIAC, telnetWill, comPortOption,
+		telnetIAC, telnetWill, telnetBinaryOpt,
+		telnetIAC, telnetDo, telnetBinaryOpt,
+	}
+	baud := make([]byte, 4)
+	binary.BigEndian.PutUint32(baud, ccBaudRate)
+	for _, sub := range [][]byte{
+		append([]byte{comSetBaudRate}, baud...),
+		{comSetDataSize, ccDataSize},
+		{comSetParity, comParityNone},
+		{comSetStopSize, comStopSizeOne},
+	} {
+		buf = append(buf, telnetIAC, telnetSB, comPortOption)
+		for _, b := range sub {
+			// Data bytes of 255 must be doubled within subnegotiation.
+			if b == telnetIAC {
+				buf = append(buf, telnetIAC)
+			}
+			buf = append(buf, b)
+		}
+		buf = append(buf, telnetIAC, telnetSE)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// Strips telnet commands from the data read from r, leaving the serial data.
+// Options offered or requested by the server, other than those sent by
+// writeRfc2217Setup, are refused by writing to w.
+type telnetReader struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+func (t *telnetReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		// Only block for the first byte, return what is available after that.
+		if n > 0 && t.r.Buffered() == 0 {
+			break
+		}
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if b != telnetIAC {
+			p[n] = b
+			n++
+			continue
+		}
+		isData, err := t.readCommand()
+		if err != nil {
+			return n, err
+		}
+		if isData {
+			p[n] = telnetIAC
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Reads the remainder of a command following IAC. Returns true if it is an
+// escaped 255 data byte.
+func (t *telnetReader) readCommand() (bool, error) {
+	cmd, err := t.r.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	switch cmd {
+	case telnetIAC:
+		return true, nil
+	case telnetWill, telnetWont, telnetDo, telnetDont:
+		opt, err := t.r.ReadByte()
+		if err != nil {
+			return false, err
+		}
+		if opt == comPortOption || opt == telnetBinaryOpt {
+			return false, nil
+		}
+		switch cmd {
+		case telnetWill:
+			_, err = t.w.Write([]byte{telnetIAC, telnetDont, opt})
+		case telnetDo:
+			_, err = t.w.Write([]byte{telnetIAC, telnetWont, opt})
+		}
+		return false, err
+	case telnetSB:
+		// Skip subnegotiation (e.g COM-PORT-OPTION acknowledgements) up to
+		// IAC SE.
+		for {
+			b, err := t.r.ReadByte()
+			if err != nil {
+				return false, err
+			}
+			if b != telnetIAC {
+				continue
+			}
+			b, err = t.r.ReadByte()
+			if err != nil {
+				return false, err
+			}
+			if b == telnetSE {
+				return false, nil
+			}
+		}
+	}
+	// Other commands (e.g NOP) have no argument.
+	return false, nil
+}
@@ -0,0 +1,159 @@
+package cc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestNetSource(addr string, rfc2217 bool) *netSource {
+	s := newNetSource(addr, rfc2217, Config{}, nil)
+	s.dialer.Timeout = time.Second
+	s.readTimeout = time.Second
+	return s
+}
+
+// Checks that the next message from s is for sensor.
+func expectMessage(t *testing.T, s *netSource, sensor int) {
+	t.Helper()
+	msg, _, err := s.readMessage()
+	if err != nil {
+		t.Fatalf("readMessage: %v", err)
+	}
+	if msg.Sensor == nil || *msg.Sensor != sensor {
+		t.Fatalf("readMessage got message %+v, want one for sensor %d", msg, sensor)
+	}
+}
+
+// Checks that s reports an error, and reconnects on the next read.
+func expectDropped(t *testing.T, s *netSource) {
+	t.Helper()
+	if _, _, err := s.readMessage(); err == nil {
+		t.Fatal("readMessage succeeded after the connection was dropped")
+	}
+	if s.conn != nil {
+		t.Error("connection not closed after error")
+	}
+}
+
+func TestNetSourceTcp(t *testing.T) {
+	addr, done := serveTest(t,
+		func(conn net.Conn) { writeString(conn, testMessage1) },
+		func(conn net.Conn) { writeString(conn, testMessage2) },
+	)
+	s := newTestNetSource(addr, false)
+	defer s.Close()
+
+	expectMessage(t, s, 0)
+	expectDropped(t, s)
+	expectMessage(t, s, 1)
+	<-done
+}
+
+func TestNetSourceRfc2217(t *testing.T) {
+	// Requests for 57600 baud 8N1, as sent by writeRfc2217Setup.
+	wantRequests := [][]byte{
+		{telnetIAC, telnetWill, comPortOption},
+		{telnetIAC, telnetSB, comPortOption, comSetBaudRate, 0x00, 0x00, 0xe1, 0x00, telnetIAC, telnetSE},
+		{telnetIAC, telnetSB, comPortOption, comSetDataSize, 8, telnetIAC, telnetSE},
+		{telnetIAC, telnetSB, comPortOption, comSetParity, comParityNone, telnetIAC, telnetSE},
+		{telnetIAC, telnetSB, comPortOption, comSetStopSize, comStopSizeOne, telnetIAC, telnetSE},
+	}
+	var setup bytes.Buffer
+	if err := writeRfc2217Setup(&setup); err != nil {
+		t.Fatal(err)
+	}
+	readSetup := func(conn net.Conn) {
+		got := make([]byte, setup.Len())
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Errorf("reading setup: %v", err)
+			return
+		}
+		for _, want := range wantRequests {
+			if !bytes.Contains(got, want) {
+				t.Errorf("setup % x does not contain % x", got, want)
+			}
+		}
+	}
+	const unsupportedOption = 5
+
+	addr, done := serveTest(t,
+		func(conn net.Conn) {
+			readSetup(conn)
+			// Acknowledge the options and settings, which are sent back with
+			// 100 added to their codes, and offer an unsupported option.
+			conn.Write([]byte{
+				telnetIAC, telnetDo, comPortOption,
+				telnetIAC, telnetSB, comPortOption, 100 + comSetBaudRate, 0x00, 0x00, 0xe1, 0x00, telnetIAC, telnetSE,
+				telnetIAC, telnetSB, comPortOption, 100 + comSetDataSize, 8, telnetIAC, telnetSE,
+				telnetIAC, telnetDo, unsupportedOption,
+			})
+			// Split the message around a subnegotiation.
+			half := len(testMessage1) / 2
+			writeString(conn, testMessage1[:half])
+			conn.Write([]byte{telnetIAC, telnetSB, comPortOption, 100 + comSetParity, comParityNone, telnetIAC, telnetSE})
+			writeString(conn, testMessage1[half:])
+
+			refusal := make([]byte, 3)
+			if _, err := io.ReadFull(conn, refusal); err != nil {
+				t.Errorf("reading refusal: %v", err)
+			} else if want := []byte{telnetIAC, telnetWont, unsupportedOption}; !bytes.Equal(refusal, want) {
+				t.Errorf("got refusal % x, want % x", refusal, want)
+			}
+		},
+		func(conn net.Conn) {
+			readSetup(conn)
+			writeString(conn, testMessage2)
+		},
+	)
+	s := newTestNetSource(addr, true)
+	defer s.Close()
+
+	expectMessage(t, s, 0)
+	expectDropped(t, s)
+	expectMessage(t, s, 1)
+	<-done
+}
+
+func TestNetSourceReadTimeout(t *testing.T) {
+	release := make(chan struct{})
+	addr, done := serveTest(t,
+		func(conn net.Conn) {
+			writeString(conn, testMessage1)
+			// Stay connected without sending anything more.
+			<-release
+		},
+		func(conn net.Conn) { writeString(conn, testMessage2) },
+	)
+	s := newTestNetSource(addr, false)
+	s.readTimeout = 50 * time.Millisecond
+	defer s.Close()
+
+	expectMessage(t, s, 0)
+	expectDropped(t, s)
+	close(release)
+	expectMessage(t, s, 1)
+	<-done
+}
+
+func TestTelnetReader(t *testing.T) {
+	const telnetNop = 241
+	input := []byte{'a', telnetIAC, telnetIAC, 'b', telnetIAC, telnetNop, 'c',
+		telnetIAC, telnetSB, comPortOption, 100 + comSetBaudRate, 0x00, 0x00, 0xe1, 0x00, telnetIAC, telnetSE,
+		telnetIAC, telnetWill, comPortOption, 'd'}
+	var refusals bytes.Buffer
+	got, err := ioutil.ReadAll(&telnetReader{r: bufio.NewReader(bytes.NewReader(input)), w: &refusals})
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if want := []byte{'a', telnetIAC, 'b', 'c', 'd'}; !bytes.Equal(got, want) {
+		t.Errorf("got % x, want % x", got, want)
+	}
+	if refusals.Len() != 0 {
+		t.Errorf("refused supported options: % x", refusals.Bytes())
+	}
+}
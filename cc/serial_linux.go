@@ -0,0 +1,75 @@
+package cc
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/huin/gocc"
+)
+
+const (
+	// Serial settings of CurrentCost devices (57600 baud 8N1).
+	serialBaud = syscall.B57600
+)
+
+// Reads messages from a CurrentCost serial device. The device is opened by
+// this package rather than by gocc, so that the XML decoder can be replaced on
+// the open device after an error (e.g a decode error from line noise), which
+// resyncs it to the next message. The device stays open until Close, as it was
+// opened with privileges that may since have been dropped.
+type serialSource struct {
+	f      *os.File
+	raw    io.Writer
+	reader *gocc.MessageReader
+}
+
+func newSerialSource(path string, raw io.Writer) (*serialSource, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := setRawSerial(f, serialBaud); err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "configure serial", Path: path, Err: err}
+	}
+	s := &serialSource{f: f, raw: raw}
+	s.resetReader()
+	return s, nil
+}
+
+func (s *serialSource) resetReader() {
+	s.reader = gocc.NewMessageReader(teeRaw(s.f, s.raw))
+}
+
+func (s *serialSource) readMessage() (*gocc.Message, time.Time, error) {
+	msg, err := s.reader.ReadMessage()
+	if err != nil {
+		// A failed decoder cannot be reused.
+		s.resetReader()
+		return nil, time.Time{}, err
+	}
+	return msg, time.Now(), nil
+}
+
+func (s *serialSource) Close() error {
+	return s.f.Close()
+}
+
+// Puts the terminal f into raw mode (8N1, no echo or line processing) at the
+// given baud rate.
+func setRawSerial(f *os.File, baud uint32) error {
+	var t syscall.Termios
+	t.Cflag = syscall.CS8 | syscall.CREAD | syscall.CLOCAL | baud
+	t.Ispeed = baud
+	t.Ospeed = baud
+	// Block until at least one byte is available.
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
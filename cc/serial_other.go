@@ -0,0 +1,35 @@
+//go:build !linux
+// +build !linux
+
+package cc
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/huin/gocc"
+)
+
+// Reads messages from a CurrentCost serial device opened by gocc. Unlike on
+// Linux, the reader is not resynced after a decode error, and the data read
+// cannot be captured.
+type serialSource struct {
+	*gocc.SerialMessageReader
+}
+
+func newSerialSource(path string, raw io.Writer) (*serialSource, error) {
+	if raw != nil {
+		return nil, errors.New("capture_path is only supported for serial devices on Linux")
+	}
+	reader, err := gocc.NewSerialMessageReader(path)
+	if err != nil {
+		return nil, err
+	}
+	return &serialSource{reader}, nil
+}
+
+func (s *serialSource) readMessage() (*gocc.Message, time.Time, error) {
+	msg, err := s.ReadMessage()
+	return msg, time.Now(), err
+}
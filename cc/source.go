@@ -0,0 +1,231 @@
+package cc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/huin/gocc"
+)
+
+const (
+	// Prefix of Config.Device that selects replaying a capture file.
+	replayDevicePrefix = "replay:"
+	// Layout of receive times in capture files.
+	captureTimeLayout = time.RFC3339Nano
+)
+
+// A source of CurrentCost messages.
+type messageSource interface {
+	// Returns the next message, and the time that it was received.
+	readMessage() (*gocc.Message, time.Time, error)
+	Close() error
+}
+
+// Opens the source of messages for cfg.Device, capturing them to
+// cfg.CapturePath if it is set.
+func openSource(cfg Config) (messageSource, error) {
+	var capture *captureSource
+	// Receives the data read from the device, if it is captured.
+	var raw io.Writer
+	if cfg.CapturePath != "" {
+		var err error
+		if capture, err = newCaptureSource(cfg.CapturePath); err != nil {
+			return nil, err
+		}
+		raw = &capture.raw
+	}
+	src, err := openDevice(cfg, raw)
+	if err != nil {
+		if capture != nil {
+			capture.f.Close()
+		}
+		return nil, err
+	}
+	if capture == nil {
+		return src, nil
+	}
+	capture.messageSource = src
+	return capture, nil
+}
+
+// Opens the device named by cfg.Device, copying the data read from it to raw
+// if raw is not nil.
+func openDevice(cfg Config, raw io.Writer) (messageSource, error) {
+	switch {
+	case strings.HasPrefix(cfg.Device, replayDevicePrefix):
+		replay, err := newReplaySource(strings.TrimPrefix(cfg.Device, replayDevicePrefix), cfg.ReplaySpeed, raw)
+		if err != nil {
+			return nil, err
+		}
+		return replay, nil
+	case strings.HasPrefix(cfg.Device, tcpDevicePrefix):
+		return newNetSource(strings.TrimPrefix(cfg.Device, tcpDevicePrefix), false, cfg, raw), nil
+	case strings.HasPrefix(cfg.Device, rfc2217DevicePrefix):
+		return newNetSource(strings.TrimPrefix(cfg.Device, rfc2217DevicePrefix), true, cfg, raw), nil
+	}
+	serial, err := newSerialSource(cfg.Device, raw)
+	if err != nil {
+		return nil, err
+	}
+	return serial, nil
+}
+
+// Returns a reader of r that also writes what it reads to raw, or r itself if
+// raw is nil.
+func teeRaw(r io.Reader, raw io.Writer) io.Reader {
+	if raw == nil {
+		return r
+	}
+	return io.TeeReader(r, raw)
+}
+
+// End tag of a CurrentCost message.
+var captureMsgEnd = []byte("</msg>")
+
+// Records the messages read from a source to a capture file, one per line, as
+// the receive time and the raw XML received, separated by a space. Line breaks
+// within a message are removed. Data that the decoder fails on is recorded in
+// the same way, so that replaying the capture fails on it too.
+type captureSource struct {
+	messageSource
+	f *os.File
+	// Data read from the device by messageSource, not yet recorded.
+	raw bytes.Buffer
+}
+
+// Opens the capture file. messageSource is set by the caller, once the device
+// is open.
+func newCaptureSource(path string) (*captureSource, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &captureSource{f: f}, nil
+}
+
+func (s *captureSource) readMessage() (*gocc.Message, time.Time, error) {
+	msg, t, err := s.messageSource.readMessage()
+	var data []byte
+	if err == nil {
+		// The decoder may have read ahead into the next message, which is
+		// left for the next read.
+		end := bytes.Index(s.raw.Bytes(), captureMsgEnd)
+		if end < 0 {
+			end = s.raw.Len()
+		} else {
+			end += len(captureMsgEnd)
+		}
+		data = s.raw.Next(end)
+	} else {
+		// The decoder, and whatever it read ahead, is discarded on error.
+		data = s.raw.Next(s.raw.Len())
+		t = time.Now()
+	}
+	data = bytes.Replace(data, []byte("\r"), nil, -1)
+	data = bytes.Replace(data, []byte("\n"), nil, -1)
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 {
+		if _, werr := fmt.Fprintf(s.f, "%s %s\n", t.Format(captureTimeLayout), data); werr != nil && err == nil {
+			return nil, t, fmt.Errorf("writing capture: %v", werr)
+		}
+	}
+	return msg, t, err
+}
+
+func (s *captureSource) Close() error {
+	err := s.messageSource.Close()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Replays messages from a capture file, with the delays between them as
+// captured, divided by speed. Messages are returned with their captured
+// receive times. Returns io.EOF at the end of the file, after which the replay
+// starts again from the beginning.
+type replaySource struct {
+	f       *os.File
+	scanner *bufio.Scanner
+	speed   float64
+	raw     io.Writer
+	// Captured receive time, and actual time, of the previous message.
+	prevCaptured time.Time
+	prevActual   time.Time
+}
+
+func newReplaySource(path string, speed float64, raw io.Writer) (*replaySource, error) {
+	if speed <= 0 {
+		speed = 1
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &replaySource{f: f, speed: speed, raw: raw}
+	s.resetScanner()
+	return s, nil
+}
+
+func (s *replaySource) resetScanner() {
+	s.scanner = bufio.NewScanner(s.f)
+	// History messages are larger than the scanner's default limit.
+	s.scanner.Buffer(nil, 1<<20)
+}
+
+func (s *replaySource) readMessage() (*gocc.Message, time.Time, error) {
+	for s.scanner.Scan() {
+		line := bytes.TrimSpace(s.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		space := bytes.IndexByte(line, ' ')
+		if space < 0 {
+			return nil, time.Time{}, fmt.Errorf("malformed capture line %q", line)
+		}
+		t, err := time.Parse(captureTimeLayout, string(line[:space]))
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		// Decoded as gocc decodes a device, so that a capture reproduces its
+		// parsing.
+		msg, err := gocc.NewMessageReader(teeRaw(bytes.NewReader(line[space+1:]), s.raw)).ReadMessage()
+		if err == io.EOF {
+			// Not the end of the replay.
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		if !s.prevCaptured.IsZero() {
+			delay := time.Duration(float64(t.Sub(s.prevCaptured))/s.speed) - time.Since(s.prevActual)
+			if delay > 0 {
+				time.Sleep(delay)
+			}
+		}
+		s.prevCaptured = t
+		s.prevActual = time.Now()
+		return msg, t, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+	// Rewind, so that the source is not left at the end of the file for the
+	// rest of its life. The first message of the next replay is not delayed.
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return nil, time.Time{}, err
+	}
+	s.resetScanner()
+	s.prevCaptured = time.Time{}
+	return nil, time.Time{}, io.EOF
+}
+
+func (s *replaySource) Close() error {
+	return s.f.Close()
+}
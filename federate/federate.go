@@ -0,0 +1,95 @@
+// Package federate aggregates the metrics of other warren instances (peers),
+// so that a single instance can export the metrics of several hosts.
+package federate
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/huin/warren/scrape"
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Label added to each peer's metrics to distinguish them.
+	instanceLabel = "instance"
+)
+
+type Config struct {
+	Peer []PeerConfig
+	// How often to scrape each peer. Defaults to 1 minute if unspecified.
+	Interval util.Duration
+	// Timeout for each scrape of a peer. Defaults to 10 seconds, or Interval if
+	// that is shorter.
+	Timeout util.Duration
+	// If scraping a peer fails, continue exporting its metrics from the last
+	// successful scrape until they are this old. Defaults to 0, which stops
+	// exporting them immediately.
+	CacheFor util.Duration `toml:"cache_for"`
+	Labels   promm.Labels
+}
+
+type PeerConfig struct {
+	// The URL of the peer's Prometheus handler, e.g "http://pi2:9000/metrics".
+	URL string
+	// The value of the "instance" label added to the peer's metrics. Defaults to
+	// the host:port of URL.
+	Instance string
+}
+
+// Collector implements prometheus.Collector for metrics scraped from peers.
+// The health of each peer is exported by the warren_scrape_* metrics, with the
+// peer's instance label. Like scrape.Collector, this is an "unchecked"
+// collector.
+type Collector struct {
+	metrics util.MetricCollection
+}
+
+func New(cfg Config, gatherer promm.Gatherer) (*Collector, error) {
+	if len(cfg.Peer) == 0 {
+		return nil, errors.New("no peers declared")
+	}
+	c := &Collector{}
+	seen := map[string]struct{}{}
+	for i, peer := range cfg.Peer {
+		instance := peer.Instance
+		if instance == "" {
+			u, err := url.Parse(peer.URL)
+			if err != nil {
+				return nil, fmt.Errorf("bad URL for peer[%d]: %v", i, err)
+			}
+			instance = u.Host
+		}
+		if _, ok := seen[instance]; ok {
+			return nil, fmt.Errorf("duplicate instance %q for peer[%d]", instance, i)
+		}
+		seen[instance] = struct{}{}
+
+		labels := promm.Labels{instanceLabel: instance}
+		for k, v := range cfg.Labels {
+			labels[k] = v
+		}
+		sc, err := scrape.New(scrape.Config{
+			URL:      peer.URL,
+			Interval: cfg.Interval,
+			Timeout:  cfg.Timeout,
+			CacheFor: cfg.CacheFor,
+			Labels:   labels,
+		}, gatherer)
+		if err != nil {
+			return nil, fmt.Errorf("%v, peer[%d]", err, i)
+		}
+		c.metrics.Add(sc)
+	}
+	return c, nil
+}
+
+func (c *Collector) Describe(ch chan<- *promm.Desc) {
+	c.metrics.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- promm.Metric) {
+	c.metrics.Collect(ch)
+}
@@ -0,0 +1,184 @@
+package federate
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	promm "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const testBody = `# TYPE temp_celsius gauge
+temp_celsius{room="hall"} 19.5
+`
+
+// Starts a peer that responds with body.
+func newTestPeer(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Returns the host:port of srv, the default instance label for it.
+func hostPort(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+// Gathers the metrics exported by c, and returns them as sorted
+// "name{labels} value" strings. Scrape meta-metrics other than up are omitted,
+// as their values vary.
+func gather(t *testing.T, c *Collector) []string {
+	t.Helper()
+	reg := promm.NewRegistry()
+	reg.MustRegister(c)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	var got []string
+	for _, mf := range mfs {
+		if strings.HasPrefix(mf.GetName(), "warren_scrape_") && mf.GetName() != "warren_scrape_up" {
+			continue
+		}
+		for _, m := range mf.Metric {
+			got = append(got, formatMetric(mf.GetName(), m))
+		}
+	}
+	sort.Strings(got)
+	return got
+}
+
+func formatMetric(name string, m *dto.Metric) string {
+	var labels []string
+	for _, lp := range m.Label {
+		labels = append(labels, fmt.Sprintf("%s=%q", lp.GetName(), lp.GetValue()))
+	}
+	var value float64
+	switch {
+	case m.Gauge != nil:
+		value = m.GetGauge().GetValue()
+	case m.Untyped != nil:
+		value = m.GetUntyped().GetValue()
+	}
+	return fmt.Sprintf("%s{%s} %g", name, strings.Join(labels, ","), value)
+}
+
+// Waits for the peers to be scraped in the background, until c exports want.
+func waitForMetrics(t *testing.T, c *Collector, want []string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := gather(t, c)
+		if strings.Join(got, "\n") == strings.Join(want, "\n") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got metrics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFederate(t *testing.T) {
+	pi2 := newTestPeer(t, testBody)
+	nas := newTestPeer(t, testBody)
+	c, err := New(Config{
+		Peer: []PeerConfig{
+			{URL: pi2.URL, Instance: "pi2"},
+			{URL: nas.URL},
+		},
+		Labels: promm.Labels{"site": "home"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	// Each peer's metrics are distinguished by its instance, which defaults to
+	// its host:port.
+	nasInstance := hostPort(t, nas)
+	waitForMetrics(t, c, []string{
+		fmt.Sprintf(`temp_celsius{instance=%q,room="hall",site="home"} 19.5`, nasInstance),
+		`temp_celsius{instance="pi2",room="hall",site="home"} 19.5`,
+		fmt.Sprintf(`warren_scrape_up{instance=%q,site="home",url=%q} 1`, nasInstance, nas.URL),
+		fmt.Sprintf(`warren_scrape_up{instance="pi2",site="home",url=%q} 1`, pi2.URL),
+	})
+}
+
+func TestFederatePeerDown(t *testing.T) {
+	up := newTestPeer(t, testBody)
+	requested := make(chan struct{}, 1)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case requested <- struct{}{}:
+		default:
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+	c, err := New(Config{
+		Peer: []PeerConfig{
+			{URL: up.URL, Instance: "up"},
+			{URL: down.URL, Instance: "down"},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	select {
+	case <-requested:
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer that is down was not scraped")
+	}
+	// The peer that is down has no metrics, and its health shows it as down.
+	waitForMetrics(t, c, []string{
+		`temp_celsius{instance="up",room="hall"} 19.5`,
+		fmt.Sprintf(`warren_scrape_up{instance="down",url=%q} 0`, down.URL),
+		fmt.Sprintf(`warren_scrape_up{instance="up",url=%q} 1`, up.URL),
+	})
+}
+
+func TestFederateDuplicatePeers(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		peers []PeerConfig
+	}{
+		{"same host", []PeerConfig{
+			{URL: "http://127.0.0.1:1/metrics"},
+			{URL: "http://127.0.0.1:1/other"},
+		}},
+		{"same instance", []PeerConfig{
+			{URL: "http://127.0.0.1:1/metrics", Instance: "pi2"},
+			{URL: "http://127.0.0.1:2/metrics", Instance: "pi2"},
+		}},
+		{"instance of other host", []PeerConfig{
+			{URL: "http://127.0.0.1:1/metrics"},
+			{URL: "http://127.0.0.1:2/metrics", Instance: "127.0.0.1:1"},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := New(Config{Peer: tc.peers}, nil); err == nil {
+				t.Error("New succeeded with duplicate instances")
+			}
+		})
+	}
+}
+
+func TestFederateNoPeers(t *testing.T) {
+	if _, err := New(Config{}, nil); err == nil {
+		t.Error("New succeeded with no peers")
+	}
+}
@@ -0,0 +1,76 @@
+package linux
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"log"
+	"unsafe"
+)
+
+const (
+	// Relative to the procfs root.
+	auxvPath = "self/auxv"
+	// Auxiliary vector entry types, from <elf.h>.
+	atNull    = 0
+	atClockTk = 17
+	// Used if the clock tick rate cannot be read from the auxiliary vector.
+	// This is the value on almost all Linux systems.
+	defaultClockTicks = 100
+)
+
+var (
+	errNoClockTicks = errors.New("AT_CLKTCK not present in auxiliary vector")
+)
+
+// Returns the length of a kernel clock tick ("jiffy") in seconds, as used for
+// times in /proc/stat and /proc/[pid]/stat. fallback is the tick rate used if
+// it cannot be read, if positive, otherwise defaultClockTicks is.
+func jiffySeconds(paths sysPaths, fallback int64) float64 {
+	clockTicks, err := readClockTicks(paths.proc(auxvPath))
+	if err != nil || clockTicks <= 0 {
+		clockTicks = fallback
+		if clockTicks <= 0 {
+			clockTicks = defaultClockTicks
+		}
+		log.Printf("Unable to read clock tick rate, assuming %d per second: %v", clockTicks, err)
+	}
+	return 1 / float64(clockTicks)
+}
+
+// Reads the kernel's clock tick rate (as used for times in /proc/stat), as
+// sysconf(_SC_CLK_TCK) would, from the AT_CLKTCK entry of an auxiliary vector
+// file such as /proc/self/auxv.
+func readClockTicks(path string) (int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	// The auxiliary vector is a list of (type, value) pairs of native-endian
+	// words, ending with an AT_NULL entry.
+	wordSize := int(unsafe.Sizeof(uintptr(0)))
+	order := nativeByteOrder()
+	word := func(b []byte) uint64 {
+		if wordSize == 8 {
+			return order.Uint64(b)
+		}
+		return uint64(order.Uint32(b))
+	}
+	for i := 0; i+2*wordSize <= len(data); i += 2 * wordSize {
+		switch word(data[i:]) {
+		case atNull:
+			return 0, errNoClockTicks
+		case atClockTk:
+			return int64(word(data[i+wordSize:])), nil
+		}
+	}
+	return 0, errNoClockTicks
+}
+
+func nativeByteOrder() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
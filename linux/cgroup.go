@@ -0,0 +1,264 @@
+package linux
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the sysfs root. The cgroup v2 hierarchy is mounted at the
+	// first of these that contains cgroup.controllers, the second being where
+	// systems with both cgroup versions ("hybrid" mode) mount it.
+	cgroupPath       = "fs/cgroup"
+	cgroupHybridPath = "fs/cgroup/unified"
+	// Relative to a cgroup's directory.
+	cgroupControllersPath = "cgroup.controllers"
+	cgroupCpuStatPath     = "cpu.stat"
+	cgroupMemoryPath      = "memory.current"
+	cgroupIoStatPath      = "io.stat"
+	// Relative to the sysfs root, for mapping major:minor device numbers to
+	// device names.
+	sysDevBlockPath = "dev/block"
+)
+
+type cgroupStat struct {
+	key   string
+	opts  promm.Opts
+	scale float64
+}
+
+var (
+	// Keys in cpu.stat that are exported, and the options for their metrics.
+	cgroupCpuStats = []cgroupStat{
+		{"usage_usec", promm.Opts{Name: "cgroup_cpu_usage_seconds", Help: "CPU time used by cgroup (seconds)."}, 1e-6},
+		{"user_usec", promm.Opts{Name: "cgroup_cpu_user_seconds", Help: "CPU time used by cgroup in user mode (seconds)."}, 1e-6},
+		{"system_usec", promm.Opts{Name: "cgroup_cpu_system_seconds", Help: "CPU time used by cgroup in kernel mode (seconds)."}, 1e-6},
+		{"nr_throttled", promm.Opts{Name: "cgroup_cpu_throttled_periods", Help: "Scheduler periods in which cgroup was throttled (count)."}, 1},
+		{"throttled_usec", promm.Opts{Name: "cgroup_cpu_throttled_seconds", Help: "Time that cgroup was throttled for (seconds)."}, 1e-6},
+	}
+	// Keys in io.stat that are exported, and the options for their metrics.
+	cgroupIoStats = []cgroupStat{
+		{"rbytes", promm.Opts{Name: "cgroup_io_read_bytes", Help: "Data read by cgroup, by device (bytes)."}, 1},
+		{"wbytes", promm.Opts{Name: "cgroup_io_written_bytes", Help: "Data written by cgroup, by device (bytes)."}, 1},
+		{"rios", promm.Opts{Name: "cgroup_io_reads", Help: "Read operations by cgroup, by device (count)."}, 1},
+		{"wios", promm.Opts{Name: "cgroup_io_writes", Help: "Write operations by cgroup, by device (count)."}, 1},
+	}
+)
+
+type CgroupConfig struct {
+	// Glob patterns (as for path.Match) matched against cgroup paths, e.g
+	// "/system.slice/*.service". Only cgroups matching any Include pattern and
+	// no Exclude pattern are exported. Include defaults to matching all
+	// cgroups.
+	Include []string
+	Exclude []string
+}
+
+type cgroupCollector struct {
+	metrics util.MetricCollection
+	paths   sysPaths
+	include []string
+	exclude []string
+	// Metrics corresponding to cgroupCpuStats and cgroupIoStats.
+	cpuStats []*util.ValueVec
+	ioStats  []*util.ValueVec
+	memory   *promm.GaugeVec
+
+	// Held by Collect, as each read resets the per-cgroup metrics.
+	mu sync.Mutex
+	// Device names by major:minor number.
+	devices map[string]string
+}
+
+func newCgroupCollector(cfg CgroupConfig, paths sysPaths, labels promm.Labels) (*cgroupCollector, error) {
+	for _, pattern := range append(append([]string(nil), cfg.Include...), cfg.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad cgroup pattern %q: %v", pattern, err)
+		}
+	}
+	cc := &cgroupCollector{
+		paths:   paths,
+		include: cfg.Include,
+		exclude: cfg.Exclude,
+		devices: make(map[string]string),
+	}
+	for _, stat := range cgroupCpuStats {
+		opts := stat.opts
+		opts.Namespace = namespace
+		opts.ConstLabels = labels
+		cc.cpuStats = append(cc.cpuStats, cc.metrics.NewValueVec(opts, promm.CounterValue, []string{"cgroup"}))
+	}
+	for _, stat := range cgroupIoStats {
+		opts := stat.opts
+		opts.Namespace = namespace
+		opts.ConstLabels = labels
+		cc.ioStats = append(cc.ioStats, cc.metrics.NewValueVec(opts, promm.CounterValue, []string{"cgroup", "device"}))
+	}
+	cc.memory = cc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "cgroup_memory_bytes",
+			Help:        "Memory used by cgroup and its descendants (bytes).",
+			ConstLabels: labels,
+		},
+		[]string{"cgroup"},
+	)
+	return cc, nil
+}
+
+func (cc *cgroupCollector) Describe(ch chan<- *promm.Desc) {
+	cc.metrics.Describe(ch)
+}
+
+func (cc *cgroupCollector) Collect(ch chan<- promm.Metric) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if err := cc.readStats(); err != nil {
+		log.Printf("Error reading cgroups: %v", err)
+	}
+	cc.metrics.Collect(ch)
+}
+
+func (cc *cgroupCollector) match(cgroup string) bool {
+	included := len(cc.include) == 0
+	for _, pattern := range cc.include {
+		if ok, _ := path.Match(pattern, cgroup); ok {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, pattern := range cc.exclude {
+		if ok, _ := path.Match(pattern, cgroup); ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (cc *cgroupCollector) readStats() error {
+	root := cc.paths.sys(cgroupPath)
+	if _, err := os.Stat(filepath.Join(root, cgroupControllersPath)); err != nil {
+		root = cc.paths.sys(cgroupHybridPath)
+		if _, err := os.Stat(filepath.Join(root, cgroupControllersPath)); err != nil {
+			return fmt.Errorf("no cgroup v2 hierarchy found: %v", err)
+		}
+	}
+
+	// Cgroups come and go (e.g with each session scope), so remove those from
+	// the previous walk.
+	for _, vec := range cc.cpuStats {
+		vec.Reset()
+	}
+	for _, vec := range cc.ioStats {
+		vec.Reset()
+	}
+	cc.memory.Reset()
+
+	return filepath.Walk(root, func(dir string, info os.FileInfo, err error) error {
+		if err != nil {
+			// The cgroup was probably removed during the walk.
+			if dir != root && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			return err
+		}
+		cgroup := path.Join("/", filepath.ToSlash(rel))
+		if cc.match(cgroup) {
+			cc.readCgroup(dir, cgroup)
+		}
+		return nil
+	})
+}
+
+// Reads the stats of a cgroup, ignoring missing files, which depend on the
+// controllers enabled for the cgroup.
+func (cc *cgroupCollector) readCgroup(dir, cgroup string) {
+	labels := promm.Labels{"cgroup": cgroup}
+	if memory, err := readIntFile(filepath.Join(dir, cgroupMemoryPath)); err == nil {
+		cc.memory.With(labels).Set(float64(memory))
+	}
+
+	readKeyedFile(filepath.Join(dir, cgroupCpuStatPath), func(values []string) {
+		// Lines are of the form "usage_usec 1234".
+		if len(values) < 2 {
+			return
+		}
+		for i, stat := range cgroupCpuStats {
+			if stat.key != values[0] {
+				continue
+			}
+			if value, err := strconv.ParseUint(values[1], 10, 64); err == nil {
+				cc.cpuStats[i].Set(labels, float64(value)*stat.scale)
+			}
+		}
+	})
+
+	readKeyedFile(filepath.Join(dir, cgroupIoStatPath), func(values []string) {
+		// Lines are of the form "8:0 rbytes=1 wbytes=2 rios=3 wios=4 ...".
+		if len(values) < 2 {
+			return
+		}
+		ioLabels := promm.Labels{"cgroup": cgroup, "device": cc.deviceName(values[0])}
+		for _, kv := range values[1:] {
+			eq := strings.IndexByte(kv, '=')
+			if eq < 0 {
+				continue
+			}
+			for i, stat := range cgroupIoStats {
+				if stat.key != kv[:eq] {
+					continue
+				}
+				if value, err := strconv.ParseUint(kv[eq+1:], 10, 64); err == nil {
+					cc.ioStats[i].Set(ioLabels, float64(value)*stat.scale)
+				}
+			}
+		}
+	})
+}
+
+// Returns the name of the block device with the given major:minor number, or
+// the number itself if the name cannot be found.
+func (cc *cgroupCollector) deviceName(majorMinor string) string {
+	if name, ok := cc.devices[majorMinor]; ok {
+		return name
+	}
+	name := majorMinor
+	if devPath, err := filepath.EvalSymlinks(cc.paths.sys(sysDevBlockPath, majorMinor)); err == nil {
+		name = filepath.Base(devPath)
+	}
+	cc.devices[majorMinor] = name
+	return name
+}
+
+// Calls fn with the whitespace separated fields of each line of a file, doing
+// nothing if the file cannot be read.
+func readKeyedFile(path string, fn func(values []string)) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fn(strings.Fields(scanner.Text()))
+	}
+}
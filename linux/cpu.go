@@ -11,13 +11,9 @@ import (
 	promm "github.com/prometheus/client_golang/prometheus"
 )
 
-/*
-#include <unistd.h>
-*/
-import "C"
-
 const (
-	cpuStatsPath  = "/proc/stat"
+	// Relative to the procfs root.
+	cpuStatsPath  = "stat"
 	intFileEnding = "\x00\n"
 )
 
@@ -25,6 +21,22 @@ var (
 	// Named CPU states for each numeric CPU column in /proc/stat. The order
 	// corresponds to the column order.
 	cpuStates = []string{"user", "nice", "system", "idle", "iowait", "irq", "softirq", "steal", "guest", "guest_nice"}
+	// Kernel activity lines in /proc/stat, following the "cpu" lines, and the
+	// options and types of the metrics exported from them. Only the first value
+	// on each line is exported (for "intr", this is the total of all
+	// interrupts).
+	kernelStats = []struct {
+		key       string
+		opts      promm.Opts
+		valueType promm.ValueType
+	}{
+		{"ctxt", promm.Opts{Name: "context_switches", Help: "Context switches since boot (count)."}, promm.CounterValue},
+		{"intr", promm.Opts{Name: "interrupts", Help: "Interrupts serviced since boot (count)."}, promm.CounterValue},
+		{"btime", promm.Opts{Name: "boot_time_seconds", Help: "Time that the system booted (seconds since epoch)."}, promm.GaugeValue},
+		{"processes", promm.Opts{Name: "forks", Help: "Processes and threads created since boot (count)."}, promm.CounterValue},
+		{"procs_running", promm.Opts{Name: "procs_running", Help: "Processes in a runnable state (count)."}, promm.GaugeValue},
+		{"procs_blocked", promm.Opts{Name: "procs_blocked", Help: "Processes blocked waiting for I/O (count)."}, promm.GaugeValue},
+	}
 )
 
 type CpuConfig struct {
@@ -36,28 +48,40 @@ type CpuConfig struct {
 	Combined bool
 	// Export individual CPU cores' states.
 	ByCore bool
+	// Kernel clock ticks per second, used if the rate cannot be read from
+	// /proc/self/auxv. Defaults to 100.
+	ClockTicks int64 `toml:"clock_ticks"`
+	// Export host_cpu_combined_seconds and host_cpu_by_core_seconds as gauges
+	// rather than counters, for compatibility with older versions.
+	SecondsAsGauges bool `toml:"seconds_as_gauges"`
+	// Export per-core frequencies from cpufreq.
+	Frequency bool
+	// Export thermal throttling event counts.
+	Throttle bool
 }
 
 type cpuCollector struct {
 	metrics      util.MetricCollection
-	combinedTime *promm.GaugeVec
-	byCoreTime   *promm.GaugeVec
+	paths        sysPaths
+	combinedTime *util.ValueVec
+	byCoreTime   *util.ValueVec
+	// Kernel activity metrics, keyed by /proc/stat line name. nil if not
+	// exported.
+	kernel map[string]*util.ValueVec
 	// Kernel jiffy time in seconds (typically 1/100 or something).
 	jiffiesScaler float64
 	// CPU metrics:
 	// bitfield, corresponds to states in cpuStates, generated from
 	// CpuConfig.States.
 	recordedStates uint32
-
-	// reuseable map for stating cpu/state labels. closely tied to readStats and
-	// exportValues.
-	metricLabels promm.Labels
 }
 
-func newCpuCollector(cfg CpuConfig, labels promm.Labels) (*cpuCollector, error) {
+// kernel enables the export of kernel activity counters, which are also read
+// from /proc/stat.
+func newCpuCollector(cfg CpuConfig, kernel bool, paths sysPaths, labels promm.Labels) (*cpuCollector, error) {
 	cc := &cpuCollector{
-		jiffiesScaler: 1 / float64(C.sysconf(C._SC_CLK_TCK)),
-		metricLabels:  make(promm.Labels),
+		paths:         paths,
+		jiffiesScaler: jiffySeconds(paths, cfg.ClockTicks),
 	}
 
 	// Geneate recordedStates bitfield value.
@@ -76,28 +100,45 @@ func newCpuCollector(cfg CpuConfig, labels promm.Labels) (*cpuCollector, error)
 		}
 	}
 
+	secondsType := promm.CounterValue
+	if cfg.SecondsAsGauges {
+		secondsType = promm.GaugeValue
+	}
+
 	if cfg.Combined {
-		cc.combinedTime = cc.metrics.NewGaugeVec(
-			promm.GaugeOpts{
+		cc.combinedTime = cc.metrics.NewValueVec(
+			promm.Opts{
 				Namespace: namespace, Name: "cpu_combined_seconds",
 				Help:        "CPU time spent in various states, combined cores (seconds).",
 				ConstLabels: labels,
 			},
+			secondsType,
 			[]string{"state"},
 		)
 	}
 
 	if cfg.ByCore {
-		cc.byCoreTime = cc.metrics.NewGaugeVec(
-			promm.GaugeOpts{
+		cc.byCoreTime = cc.metrics.NewValueVec(
+			promm.Opts{
 				Namespace: namespace, Name: "cpu_by_core_seconds",
 				Help:        "CPU time spent in various states, per core (seconds).",
 				ConstLabels: labels,
 			},
+			secondsType,
 			[]string{"core", "state"},
 		)
 	}
 
+	if kernel {
+		cc.kernel = make(map[string]*util.ValueVec, len(kernelStats))
+		for _, ks := range kernelStats {
+			opts := ks.opts
+			opts.Namespace = namespace
+			opts.ConstLabels = labels
+			cc.kernel[ks.key] = cc.metrics.NewValueVec(opts, ks.valueType, nil)
+		}
+	}
+
 	return cc, nil
 }
 
@@ -113,33 +154,36 @@ func (cc *cpuCollector) Collect(ch chan<- promm.Metric) {
 }
 
 func (cc *cpuCollector) readStats() error {
-	data, err := ioutil.ReadFile(cpuStatsPath)
+	data, err := ioutil.ReadFile(cc.paths.proc(cpuStatsPath))
 	if err != nil {
 		return err
 	}
 	s := string(data)
 	for _, l := range strings.Split(s, "\n") {
 		if !strings.HasPrefix(l, "cpu") {
-			// "cpu" lines are all at the start, skip the remainder.
-			break
+			if cc.kernel == nil {
+				// "cpu" lines are all at the start, skip the remainder.
+				break
+			}
+			cc.exportKernelValue(strings.Fields(l))
+			continue
 		}
 		values := strings.Fields(l)
 		if values[0] == "cpu" {
 			if cc.combinedTime != nil {
-				delete(cc.metricLabels, "core")
-				cc.exportValues(values[1:], cc.combinedTime)
+				cc.exportValues("", values[1:], cc.combinedTime)
 			}
 		} else if cc.byCoreTime != nil {
-			cc.metricLabels["core"] = values[0]
-			cc.exportValues(values[1:], cc.byCoreTime)
+			cc.exportValues(values[0], values[1:], cc.byCoreTime)
 		}
 	}
 	return nil
 }
 
-// values should be a "cpu"-prefixed set of values from /proc/stat.
-// cc.metricLabels must have "core" label set or removed as appropriate for cv.
-func (cc *cpuCollector) exportValues(values []string, cv *promm.GaugeVec) {
+// values should be a "cpu"-prefixed set of values from /proc/stat. core is the
+// "core" label for cv, or empty for the combined values. The labels are built
+// per call, as Collect may be called concurrently.
+func (cc *cpuCollector) exportValues(core string, values []string, cv *util.ValueVec) {
 	for stateIndex, valueStr := range values {
 		if stateIndex > len(cpuStates) {
 			break
@@ -149,8 +193,27 @@ func (cc *cpuCollector) exportValues(values []string, cv *promm.GaugeVec) {
 			if err != nil {
 				continue
 			}
-			cc.metricLabels["state"] = cpuStates[stateIndex]
-			cv.With(cc.metricLabels).Set(float64(jiffies) * cc.jiffiesScaler)
+			labels := promm.Labels{"state": cpuStates[stateIndex]}
+			if core != "" {
+				labels["core"] = core
+			}
+			cv.Set(labels, float64(jiffies)*cc.jiffiesScaler)
 		}
 	}
 }
+
+// values should be a non-"cpu" line from /proc/stat, split into fields.
+func (cc *cpuCollector) exportKernelValue(values []string) {
+	if len(values) < 2 {
+		return
+	}
+	v, ok := cc.kernel[values[0]]
+	if !ok {
+		return
+	}
+	value, err := strconv.ParseUint(values[1], 10, 64)
+	if err != nil {
+		return
+	}
+	v.Set(nil, float64(value))
+}
@@ -0,0 +1,149 @@
+package linux
+
+import (
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strconv"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the sysfs root.
+	sysCpuPath = "devices/system/cpu"
+	// Relative to a core's directory in sysCpuPath.
+	cpuFreqCurPath           = "cpufreq/scaling_cur_freq"
+	cpuFreqMinPath           = "cpufreq/scaling_min_freq"
+	cpuFreqMaxPath           = "cpufreq/scaling_max_freq"
+	coreThrottleCountPath    = "thermal_throttle/core_throttle_count"
+	packageThrottleCountPath = "thermal_throttle/package_throttle_count"
+	physicalPackageIdPath    = "topology/physical_package_id"
+)
+
+var (
+	// Matches core directory names in sysCpuPath, e.g "cpu0".
+	cpuCoreDirRe = regexp.MustCompile(`^cpu\d+$`)
+)
+
+type cpuFreqCollector struct {
+	metrics util.MetricCollection
+	paths   sysPaths
+	// Frequency metrics, nil if not exported.
+	freqCur *promm.GaugeVec
+	freqMin *promm.GaugeVec
+	freqMax *promm.GaugeVec
+	// Throttling metrics, nil if not exported.
+	coreThrottles    *util.ValueVec
+	packageThrottles *util.ValueVec
+}
+
+func newCpuFreqCollector(cfg CpuConfig, paths sysPaths, labels promm.Labels) *cpuFreqCollector {
+	fc := &cpuFreqCollector{paths: paths}
+	if cfg.Frequency {
+		fc.freqCur = fc.metrics.NewGaugeVec(
+			promm.GaugeOpts{
+				Namespace: namespace, Name: "cpu_frequency_hertz",
+				Help:        "Current CPU frequency, per core (hertz).",
+				ConstLabels: labels,
+			},
+			[]string{"core"},
+		)
+		fc.freqMin = fc.metrics.NewGaugeVec(
+			promm.GaugeOpts{
+				Namespace: namespace, Name: "cpu_frequency_min_hertz",
+				Help:        "Minimum CPU frequency allowed by the scaling policy, per core (hertz).",
+				ConstLabels: labels,
+			},
+			[]string{"core"},
+		)
+		fc.freqMax = fc.metrics.NewGaugeVec(
+			promm.GaugeOpts{
+				Namespace: namespace, Name: "cpu_frequency_max_hertz",
+				Help:        "Maximum CPU frequency allowed by the scaling policy, per core (hertz).",
+				ConstLabels: labels,
+			},
+			[]string{"core"},
+		)
+	}
+	if cfg.Throttle {
+		fc.coreThrottles = fc.metrics.NewValueVec(
+			promm.Opts{
+				Namespace: namespace, Name: "cpu_core_throttles",
+				Help:        "Times that the core has been thermally throttled, per core (count).",
+				ConstLabels: labels,
+			},
+			promm.CounterValue,
+			[]string{"core"},
+		)
+		fc.packageThrottles = fc.metrics.NewValueVec(
+			promm.Opts{
+				Namespace: namespace, Name: "cpu_package_throttles",
+				Help:        "Times that the CPU package has been thermally throttled, per package (count).",
+				ConstLabels: labels,
+			},
+			promm.CounterValue,
+			[]string{"package"},
+		)
+	}
+	return fc
+}
+
+func (fc *cpuFreqCollector) Describe(ch chan<- *promm.Desc) {
+	fc.metrics.Describe(ch)
+}
+
+func (fc *cpuFreqCollector) Collect(ch chan<- promm.Metric) {
+	if err := fc.readStats(); err != nil {
+		log.Printf("Error reading CPU frequencies: %v", err)
+	}
+	fc.metrics.Collect(ch)
+}
+
+func (fc *cpuFreqCollector) readStats() error {
+	entries, err := ioutil.ReadDir(fc.paths.sys(sysCpuPath))
+	if err != nil {
+		return err
+	}
+	packages := map[int64]bool{}
+	for _, entry := range entries {
+		core := entry.Name()
+		if !cpuCoreDirRe.MatchString(core) {
+			continue
+		}
+		labels := promm.Labels{"core": core}
+		// The files are missing on systems without cpufreq or thermal throttle
+		// support, and for offline cores.
+		if fc.freqCur != nil {
+			for _, f := range []struct {
+				vec  *promm.GaugeVec
+				path string
+			}{
+				{fc.freqCur, cpuFreqCurPath},
+				{fc.freqMin, cpuFreqMinPath},
+				{fc.freqMax, cpuFreqMaxPath},
+			} {
+				// Frequencies are in kHz.
+				if khz, err := readIntFile(fc.paths.sys(sysCpuPath, core, f.path)); err == nil {
+					f.vec.With(labels).Set(float64(khz) * 1000)
+				}
+			}
+		}
+		if fc.coreThrottles != nil {
+			if count, err := readIntFile(fc.paths.sys(sysCpuPath, core, coreThrottleCountPath)); err == nil {
+				fc.coreThrottles.Set(labels, float64(count))
+			}
+			// Each core in a package reports the package's count.
+			pkg, err := readIntFile(fc.paths.sys(sysCpuPath, core, physicalPackageIdPath))
+			if err != nil || packages[pkg] {
+				continue
+			}
+			if count, err := readIntFile(fc.paths.sys(sysCpuPath, core, packageThrottleCountPath)); err == nil {
+				packages[pkg] = true
+				fc.packageThrottles.Set(promm.Labels{"package": strconv.FormatInt(pkg, 10)}, float64(count))
+			}
+		}
+	}
+	return nil
+}
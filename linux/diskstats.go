@@ -0,0 +1,168 @@
+package linux
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the procfs root.
+	diskStatsPath = "diskstats"
+	// Relative to the sysfs root.
+	sysBlockPath = "block"
+	// Relative to a device's directory in sysBlockPath.
+	logicalBlockSizePath    = "queue/logical_block_size"
+	diskStatsDefaultExclude = `^(ram|loop|fd|zram)\d+$`
+	// /proc/diskstats counts sectors in units of 512 bytes, regardless of the
+	// device's logical block size (see the kernel's
+	// Documentation/admin-guide/iostats.rst), so that is not used to convert
+	// them.
+	diskSectorBytes = 512
+)
+
+var (
+	// Columns in /proc/diskstats following the major, minor and device name
+	// columns, and the options and types of the metrics exported from them.
+	// The order corresponds to the column order. scale converts the column's
+	// value to the metric's unit. All but disk_io_now only ever increase.
+	diskStatsColumns = []struct {
+		opts      promm.Opts
+		valueType promm.ValueType
+		scale     float64
+	}{
+		{promm.Opts{Name: "disk_reads_completed", Help: "Reads completed by device (count)."}, promm.CounterValue, 1},
+		{promm.Opts{Name: "disk_reads_merged", Help: "Adjacent reads merged by device (count)."}, promm.CounterValue, 1},
+		{promm.Opts{Name: "disk_read_bytes", Help: "Data read by device (bytes)."}, promm.CounterValue, diskSectorBytes},
+		{promm.Opts{Name: "disk_read_seconds", Help: "Time spent reading by device (seconds)."}, promm.CounterValue, 0.001},
+		{promm.Opts{Name: "disk_writes_completed", Help: "Writes completed by device (count)."}, promm.CounterValue, 1},
+		{promm.Opts{Name: "disk_writes_merged", Help: "Adjacent writes merged by device (count)."}, promm.CounterValue, 1},
+		{promm.Opts{Name: "disk_written_bytes", Help: "Data written by device (bytes)."}, promm.CounterValue, diskSectorBytes},
+		{promm.Opts{Name: "disk_write_seconds", Help: "Time spent writing by device (seconds)."}, promm.CounterValue, 0.001},
+		{promm.Opts{Name: "disk_io_now", Help: "I/Os currently in progress by device (count)."}, promm.GaugeValue, 1},
+		{promm.Opts{Name: "disk_io_seconds", Help: "Time spent doing I/O by device (seconds)."}, promm.CounterValue, 0.001},
+		{promm.Opts{Name: "disk_io_weighted_seconds", Help: "Time spent doing I/O by device, weighted by I/Os in progress (seconds)."}, promm.CounterValue, 0.001},
+	}
+)
+
+type DiskStatsConfig struct {
+	// re2 pattern matched against device names (e.g "sda"). Only matching
+	// devices are exported. Defaults to matching all devices.
+	Include string
+	// re2 pattern matched against device names. Matching devices are not
+	// exported. Defaults to excluding RAM, loop, floppy and zram devices.
+	Exclude string
+}
+
+type diskStatsCollector struct {
+	metrics          util.MetricCollection
+	paths            sysPaths
+	filter           nameFilter
+	columns          []*util.ValueVec
+	logicalBlockSize *promm.GaugeVec
+
+	// Guards devices, which readStats replaces.
+	mu sync.Mutex
+	// Devices exported by the last read, so that the metrics of devices that
+	// have since been removed can be deleted.
+	devices map[string]struct{}
+}
+
+func newDiskStatsCollector(cfg DiskStatsConfig, paths sysPaths, labels promm.Labels) (*diskStatsCollector, error) {
+	filter, err := newNameFilter(cfg.Include, cfg.Exclude, diskStatsDefaultExclude)
+	if err != nil {
+		return nil, err
+	}
+	dc := &diskStatsCollector{
+		paths:   paths,
+		filter:  filter,
+		columns: make([]*util.ValueVec, 0, len(diskStatsColumns)),
+		devices: make(map[string]struct{}),
+	}
+	for _, col := range diskStatsColumns {
+		opts := col.opts
+		opts.Namespace = namespace
+		opts.ConstLabels = labels
+		dc.columns = append(dc.columns, dc.metrics.NewValueVec(opts, col.valueType, []string{"device"}))
+	}
+	dc.logicalBlockSize = dc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "disk_logical_block_size_bytes",
+			Help:        "Logical block size by device (bytes).",
+			ConstLabels: labels,
+		},
+		[]string{"device"},
+	)
+	return dc, nil
+}
+
+func (dc *diskStatsCollector) Describe(ch chan<- *promm.Desc) {
+	dc.metrics.Describe(ch)
+}
+
+func (dc *diskStatsCollector) Collect(ch chan<- promm.Metric) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if err := dc.readStats(); err != nil {
+		log.Printf("Error reading disk stats: %v", err)
+	}
+	dc.metrics.Collect(ch)
+}
+
+func (dc *diskStatsCollector) readStats() error {
+	f, err := os.Open(dc.paths.proc(diskStatsPath))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	devices := make(map[string]struct{}, len(dc.devices))
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		values := strings.Fields(scanner.Text())
+		if len(values) < 3 {
+			continue
+		}
+		device := values[2]
+		if !dc.filter.match(device) {
+			continue
+		}
+		devices[device] = struct{}{}
+		labels := promm.Labels{"device": device}
+		for i, valueStr := range values[3:] {
+			if i >= len(dc.columns) {
+				break
+			}
+			value, err := strconv.ParseUint(valueStr, 10, 64)
+			if err != nil {
+				continue
+			}
+			dc.columns[i].Set(labels, float64(value)*diskStatsColumns[i].scale)
+		}
+		// Partitions do not have their own queue directory, so only whole
+		// devices have a logical block size.
+		readIntFileIntoGaugeVec(dc.logicalBlockSize, labels,
+			dc.paths.sys(sysBlockPath, device, logicalBlockSizePath))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	// Devices (e.g USB disks) come and go.
+	for device := range dc.devices {
+		if _, ok := devices[device]; ok {
+			continue
+		}
+		labels := promm.Labels{"device": device}
+		for _, col := range dc.columns {
+			col.Delete(labels)
+		}
+		dc.logicalBlockSize.Delete(labels)
+	}
+	dc.devices = devices
+	return nil
+}
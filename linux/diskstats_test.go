@@ -0,0 +1,76 @@
+package linux
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskStats(t *testing.T) {
+	dir := t.TempDir()
+	paths := sysPaths{procRoot: filepath.Join(dir, "proc"), sysRoot: filepath.Join(dir, "sys")}
+	writeFile := func(path, data string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(paths.sys(sysBlockPath, "sda", logicalBlockSizePath), "4096\n")
+	writeFile(paths.proc(diskStatsPath), `   7       0 loop0 10 0 20 1 0 0 0 0 0 1 1 0 0 0 0
+   8       0 sda 1000 10 8000 2500 500 5 4000 1500 2 3000 4000 0 0 0 0
+   8       1 sda1 900 10 7000 2000 400 5 3000 1000 0 2500 3000 0 0 0 0
+   8      16 sdb 100 0 800 100 0 0 0 0 0 100 100
+`)
+
+	dc, err := newDiskStatsCollector(DiskStatsConfig{Include: "^sd"}, paths, nil)
+	if err != nil {
+		t.Fatalf("newDiskStatsCollector: %v", err)
+	}
+	checkLines(t, gatherLines(t, dc, "host_disk_"), []string{
+		`counter host_disk_io_seconds{device="sda"} 3`,
+		`counter host_disk_io_seconds{device="sda1"} 2.5`,
+		`counter host_disk_io_seconds{device="sdb"} 0.1`,
+		`counter host_disk_io_weighted_seconds{device="sda"} 4`,
+		`counter host_disk_io_weighted_seconds{device="sda1"} 3`,
+		`counter host_disk_io_weighted_seconds{device="sdb"} 0.1`,
+		`counter host_disk_read_bytes{device="sda"} 4096000`,
+		`counter host_disk_read_bytes{device="sda1"} 3584000`,
+		`counter host_disk_read_bytes{device="sdb"} 409600`,
+		`counter host_disk_read_seconds{device="sda"} 2.5`,
+		`counter host_disk_read_seconds{device="sda1"} 2`,
+		`counter host_disk_read_seconds{device="sdb"} 0.1`,
+		`counter host_disk_reads_completed{device="sda"} 1000`,
+		`counter host_disk_reads_completed{device="sda1"} 900`,
+		`counter host_disk_reads_completed{device="sdb"} 100`,
+		`counter host_disk_reads_merged{device="sda"} 10`,
+		`counter host_disk_reads_merged{device="sda1"} 10`,
+		`counter host_disk_reads_merged{device="sdb"} 0`,
+		`counter host_disk_write_seconds{device="sda"} 1.5`,
+		`counter host_disk_write_seconds{device="sda1"} 1`,
+		`counter host_disk_write_seconds{device="sdb"} 0`,
+		`counter host_disk_writes_completed{device="sda"} 500`,
+		`counter host_disk_writes_completed{device="sda1"} 400`,
+		`counter host_disk_writes_completed{device="sdb"} 0`,
+		`counter host_disk_writes_merged{device="sda"} 5`,
+		`counter host_disk_writes_merged{device="sda1"} 5`,
+		`counter host_disk_writes_merged{device="sdb"} 0`,
+		`counter host_disk_written_bytes{device="sda"} 2048000`,
+		`counter host_disk_written_bytes{device="sda1"} 1536000`,
+		`counter host_disk_written_bytes{device="sdb"} 0`,
+		`gauge host_disk_io_now{device="sda"} 2`,
+		`gauge host_disk_io_now{device="sda1"} 0`,
+		`gauge host_disk_io_now{device="sdb"} 0`,
+		`gauge host_disk_logical_block_size_bytes{device="sda"} 4096`,
+	})
+
+	// The metrics of removed devices are deleted.
+	writeFile(paths.proc(diskStatsPath), `   8       0 sda 1001 10 8008 2500 500 5 4000 1500 0 3000 4000 0 0 0 0
+`)
+	checkLines(t, gatherLines(t, dc, "host_disk_reads_completed"), []string{
+		`counter host_disk_reads_completed{device="sda"} 1001`,
+	})
+}
@@ -0,0 +1,394 @@
+package linux
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the procfs root.
+	mountInfoPath          = "self/mountinfo"
+	fsDefaultTimeout       = 5 * time.Second
+	fsDefaultFsTypeExclude = `^(autofs|binfmt_misc|bpf|cgroup2?|configfs|debugfs|devpts|devtmpfs|efivarfs|fusectl|hugetlbfs|mqueue|nsfs|overlay|proc|pstore|ramfs|rpc_pipefs|securityfs|selinuxfs|squashfs|sysfs|tmpfs|tracefs)$`
+	fsDefaultMountExclude  = `^/(dev|proc|run|sys)($|/)`
+	// Values for the "result" label of fs_stat_ops_count.
+	fsStatResultOK      = "ok"
+	fsStatResultError   = "error"
+	fsStatResultTimeout = "timeout"
+	// Count of fields in a mountinfo line before the optional fields.
+	mountInfoFixedFields = 6
+)
+
+var (
+	errStatfsTimeout = errors.New("timed out")
+)
+
+type FsDiscoveryConfig struct {
+	// re2 patterns matched against filesystem types (e.g "ext4"). Only
+	// filesystems matching FsTypeInclude and not matching FsTypeExclude are
+	// exported. FsTypeInclude defaults to matching all types, and FsTypeExclude
+	// defaults to excluding virtual filesystems such as tmpfs, proc and overlay.
+	FsTypeInclude string `toml:"fstype_include"`
+	FsTypeExclude string `toml:"fstype_exclude"`
+	// re2 patterns matched against mount points. Only filesystems matching
+	// MountInclude and not matching MountExclude are exported. MountInclude
+	// defaults to matching all mount points, and MountExclude defaults to
+	// excluding those under /dev, /proc, /run and /sys.
+	MountInclude string `toml:"mount_include"`
+	MountExclude string `toml:"mount_exclude"`
+}
+
+// A filesystem mount, from /proc/self/mountinfo.
+type mountInfo struct {
+	mountPoint string
+	fsType     string
+	device     string
+}
+
+type statfsResult struct {
+	stat syscall.Statfs_t
+	err  error
+}
+
+type fsCollector struct {
+	metrics     util.MetricCollection
+	paths       sysPaths
+	filesystems []string
+	discover    bool
+	fsTypes     nameFilter
+	mounts      nameFilter
+	timeout     time.Duration
+	// Meta-metrics:
+	fsStatOps *promm.CounterVec
+	// Filesystem metrics:
+	fsSizeBytes       *promm.GaugeVec
+	fsFreeBytes       *promm.GaugeVec
+	fsUnprivFreeBytes *promm.GaugeVec
+	fsFiles           *promm.GaugeVec
+	fsFilesFree       *promm.GaugeVec
+	// Called to stat a filesystem, replaced in tests.
+	statfs func(path string, stat *syscall.Statfs_t) error
+
+	// Guards pending and exported, which Collect updates.
+	mu sync.Mutex
+	// Statfs calls that timed out and have not yet returned, by path. A later
+	// Collect waits on these rather than making another call that would likely
+	// also hang.
+	pending map[string]chan statfsResult
+	// Labels of the filesystem metrics exported by the last Collect, by mount
+	// point, so that they can be deleted once the filesystem cannot be stat'ed.
+	exported map[string]promm.Labels
+}
+
+func newFsCollector(filesystems []string, discovery *FsDiscoveryConfig, timeout time.Duration, paths sysPaths, labels promm.Labels) (*fsCollector, error) {
+	fsLabelNames := []string{"mount", "device", "fstype"}
+	if timeout == 0 {
+		timeout = fsDefaultTimeout
+	}
+	fc := &fsCollector{
+		paths:       paths,
+		filesystems: filesystems,
+		timeout:     timeout,
+		statfs:      syscall.Statfs,
+		pending:     make(map[string]chan statfsResult),
+		exported:    make(map[string]promm.Labels),
+	}
+	if discovery != nil {
+		var err error
+		fc.discover = true
+		if fc.fsTypes, err = newNameFilter(discovery.FsTypeInclude, discovery.FsTypeExclude, fsDefaultFsTypeExclude); err != nil {
+			return nil, err
+		}
+		if fc.mounts, err = newNameFilter(discovery.MountInclude, discovery.MountExclude, fsDefaultMountExclude); err != nil {
+			return nil, err
+		}
+	}
+
+	// Meta-metrics:
+	fc.fsStatOps = fc.metrics.NewCounterVec(
+		promm.CounterOpts{
+			Namespace: namespace, Name: "fs_stat_ops_count",
+			Help:        "Statfs calls by mount and result (call count).",
+			ConstLabels: labels,
+		},
+		[]string{"mount", "result"},
+	)
+	// Filesystem metrics:
+	fc.fsSizeBytes = fc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "fs_size_bytes",
+			Help:        "Filesystem capacity (bytes).",
+			ConstLabels: labels,
+		},
+		fsLabelNames,
+	)
+	fc.fsFreeBytes = fc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "fs_free_bytes",
+			Help:        "Filesystem free space (bytes).",
+			ConstLabels: labels,
+		},
+		fsLabelNames,
+	)
+	fc.fsUnprivFreeBytes = fc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "fs_unpriv_free_bytes",
+			Help:        "Filesystem unpriviledged free space (bytes).",
+			ConstLabels: labels,
+		},
+		fsLabelNames,
+	)
+	fc.fsFiles = fc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "fs_files",
+			Help:        "File count (files).",
+			ConstLabels: labels,
+		},
+		fsLabelNames,
+	)
+	fc.fsFilesFree = fc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "fs_free_files",
+			Help:        "File free count (files).",
+			ConstLabels: labels,
+		},
+		fsLabelNames,
+	)
+	return fc, nil
+}
+
+func (fc *fsCollector) Describe(ch chan<- *promm.Desc) {
+	fc.metrics.Describe(ch)
+}
+
+func (fc *fsCollector) Collect(ch chan<- promm.Metric) {
+	if len(fc.filesystems) == 0 && !fc.discover {
+		return
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	mounts, err := readMountInfo(fc.paths.proc(mountInfoPath))
+	if err != nil {
+		log.Printf("Error reading mounts: %v", err)
+	}
+
+	var toStat []mountInfo
+	for _, fs := range fc.filesystems {
+		mi := mountContaining(mounts, fs)
+		mi.mountPoint = fs
+		toStat = append(toStat, mi)
+	}
+	if fc.discover {
+		configured := make(map[string]struct{}, len(fc.filesystems))
+		for _, fs := range fc.filesystems {
+			configured[fs] = struct{}{}
+		}
+		for _, mi := range mounts {
+			if _, ok := configured[mi.mountPoint]; ok {
+				continue
+			}
+			if fc.fsTypes.match(mi.fsType) && fc.mounts.match(mi.mountPoint) {
+				toStat = append(toStat, mi)
+			}
+		}
+	}
+
+	// Stat all filesystems in parallel, so that hung mounts delay a Collect
+	// by at most one timeout between them.
+	calls := make([]chan statfsResult, len(toStat))
+	for i, mi := range toStat {
+		calls[i] = fc.startStatfs(fc.paths.root(mi.mountPoint))
+	}
+	timer := time.NewTimer(fc.timeout)
+	defer timer.Stop()
+	expired := false
+	exported := make(map[string]promm.Labels, len(toStat))
+	for i, mi := range toStat {
+		var r statfsResult
+		if expired {
+			select {
+			case r = <-calls[i]:
+			default:
+				r.err = errStatfsTimeout
+			}
+		} else {
+			select {
+			case r = <-calls[i]:
+			case <-timer.C:
+				expired = true
+				r.err = errStatfsTimeout
+			}
+		}
+		path := fc.paths.root(mi.mountPoint)
+		if r.err == errStatfsTimeout {
+			fc.pending[path] = calls[i]
+		} else {
+			delete(fc.pending, path)
+		}
+		if labels, ok := fc.exportStatfs(mi, r); ok {
+			exported[mi.mountPoint] = labels
+		}
+	}
+
+	// Remove the metrics of filesystems that are no longer mounted or could
+	// not be stat'ed, rather than exporting stale values.
+	for mountPoint, labels := range fc.exported {
+		if newLabels, ok := exported[mountPoint]; ok && labelsEqual(labels, newLabels) {
+			continue
+		}
+		fc.fsSizeBytes.Delete(labels)
+		fc.fsFreeBytes.Delete(labels)
+		fc.fsUnprivFreeBytes.Delete(labels)
+		fc.fsFiles.Delete(labels)
+		fc.fsFilesFree.Delete(labels)
+	}
+	fc.exported = exported
+
+	fc.metrics.Collect(ch)
+}
+
+// Exports the result of stating a filesystem, returning the labels of its
+// metrics if it was stat'ed successfully.
+func (fc *fsCollector) exportStatfs(mi mountInfo, r statfsResult) (promm.Labels, bool) {
+	if r.err != nil {
+		log.Printf("Error stating filesystem %q: %v", mi.mountPoint, r.err)
+		result := fsStatResultError
+		if r.err == errStatfsTimeout {
+			result = fsStatResultTimeout
+		}
+		fc.fsStatOps.With(promm.Labels{"mount": mi.mountPoint, "result": result}).Inc()
+		return nil, false
+	}
+	fc.fsStatOps.With(promm.Labels{"mount": mi.mountPoint, "result": fsStatResultOK}).Inc()
+	mountLabels := promm.Labels{"mount": mi.mountPoint, "device": mi.device, "fstype": mi.fsType}
+	bs := uint64(r.stat.Bsize)
+	fc.fsSizeBytes.With(mountLabels).Set(float64(bs * r.stat.Blocks))
+	fc.fsFreeBytes.With(mountLabels).Set(float64(bs * r.stat.Bfree))
+	fc.fsUnprivFreeBytes.With(mountLabels).Set(float64(bs * r.stat.Bavail))
+	fc.fsFiles.With(mountLabels).Set(float64(r.stat.Files))
+	fc.fsFilesFree.With(mountLabels).Set(float64(r.stat.Ffree))
+	return mountLabels, true
+}
+
+// Starts a statfs call on path in a separate goroutine, which remains blocked
+// if the call hangs (e.g on a dead network filesystem). If an earlier call on
+// path timed out and has not yet returned, its channel is returned instead.
+func (fc *fsCollector) startStatfs(path string) chan statfsResult {
+	if ch, ok := fc.pending[path]; ok {
+		return ch
+	}
+	ch := make(chan statfsResult, 1)
+	go func() {
+		var r statfsResult
+		r.err = fc.statfs(path, &r.stat)
+		ch <- r
+	}()
+	return ch
+}
+
+func labelsEqual(a, b promm.Labels) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if b[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Reads mounts from a mountinfo file. If a mount point appears more than once,
+// then only the last (i.e visible) mount is returned.
+func readMountInfo(path string) ([]mountInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []mountInfo
+	index := map[string]int{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines are of the form:
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		// There are zero or more optional fields (e.g "master:1") before "-".
+		values := strings.Fields(scanner.Text())
+		if len(values) < mountInfoFixedFields {
+			continue
+		}
+		sep := -1
+		for i := mountInfoFixedFields; i < len(values); i++ {
+			if values[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || sep+2 >= len(values) {
+			continue
+		}
+		mi := mountInfo{
+			mountPoint: unescapeMountInfo(values[4]),
+			fsType:     values[sep+1],
+			device:     unescapeMountInfo(values[sep+2]),
+		}
+		if i, ok := index[mi.mountPoint]; ok {
+			mounts[i] = mi
+		} else {
+			index[mi.mountPoint] = len(mounts)
+			mounts = append(mounts, mi)
+		}
+	}
+	return mounts, scanner.Err()
+}
+
+// Replaces octal escapes (e.g "\040" for a space) in mountinfo fields.
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Returns the mount that contains path, i.e the mount with the longest mount
+// point that is path or a parent of it. Returns a zero mountInfo if none do.
+func mountContaining(mounts []mountInfo, path string) mountInfo {
+	path = filepath.Clean(path)
+	var best mountInfo
+	for _, mi := range mounts {
+		mp := mi.mountPoint
+		if mp != path && mp != "/" && !strings.HasPrefix(path, mp+"/") {
+			continue
+		}
+		if mp == "/" && !strings.HasPrefix(path, "/") {
+			continue
+		}
+		if len(mp) > len(best.mountPoint) {
+			best = mi
+		}
+	}
+	return best
+}
@@ -0,0 +1,138 @@
+package linux
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const testMountInfo = `21 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+22 21 8:2 / /data rw,relatime shared:2 - ext4 /dev/sda2 rw
+23 21 0:5 / /nfs rw,relatime shared:3 - nfs server:/export rw
+24 21 0:6 / /backup rw,relatime shared:4 - nfs server:/backup rw
+`
+
+// Fake statfs, giving the same values for every path that exists. Calls on
+// paths in hang block until release is closed.
+type testStatfs struct {
+	hang    map[string]bool
+	release chan struct{}
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (s *testStatfs) statfs(path string, stat *syscall.Statfs_t) error {
+	s.mu.Lock()
+	s.calls[path]++
+	s.mu.Unlock()
+	if s.hang[path] {
+		<-s.release
+	}
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	stat.Bsize = 4096
+	stat.Blocks = 1000
+	stat.Bfree = 600
+	stat.Bavail = 500
+	stat.Files = 100
+	stat.Ffree = 90
+	return nil
+}
+
+// Returns paths with a procfs root containing testMountInfo, and a root
+// filesystem containing the mount points in it.
+func testFsPaths(t *testing.T) sysPaths {
+	t.Helper()
+	dir := t.TempDir()
+	paths := sysPaths{procRoot: filepath.Join(dir, "proc"), rootFs: filepath.Join(dir, "host")}
+	if err := os.MkdirAll(filepath.Dir(paths.proc(mountInfoPath)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(paths.proc(mountInfoPath), []byte(testMountInfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, mp := range []string{"/data", "/nfs", "/backup"} {
+		if err := os.MkdirAll(paths.root(mp), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return paths
+}
+
+func TestFsRootAndRemoval(t *testing.T) {
+	paths := testFsPaths(t)
+	fc, err := newFsCollector([]string{"/data"}, nil, time.Second, paths, nil)
+	if err != nil {
+		t.Fatalf("newFsCollector: %v", err)
+	}
+	fake := &testStatfs{calls: map[string]int{}}
+	fc.statfs = fake.statfs
+
+	checkLines(t, gatherLines(t, fc, "host_fs_"), []string{
+		`counter host_fs_stat_ops_count{mount="/data",result="ok"} 1`,
+		`gauge host_fs_files{device="/dev/sda2",fstype="ext4",mount="/data"} 100`,
+		`gauge host_fs_free_bytes{device="/dev/sda2",fstype="ext4",mount="/data"} 2457600`,
+		`gauge host_fs_free_files{device="/dev/sda2",fstype="ext4",mount="/data"} 90`,
+		`gauge host_fs_size_bytes{device="/dev/sda2",fstype="ext4",mount="/data"} 4096000`,
+		`gauge host_fs_unpriv_free_bytes{device="/dev/sda2",fstype="ext4",mount="/data"} 2048000`,
+	})
+	if fake.calls[paths.root("/data")] != 1 {
+		t.Errorf("statfs calls = %v, want one beneath the root filesystem", fake.calls)
+	}
+
+	// The filesystem's metrics are removed once it cannot be stat'ed.
+	if err := os.Remove(paths.root("/data")); err != nil {
+		t.Fatal(err)
+	}
+	checkLines(t, gatherLines(t, fc, "host_fs_"), []string{
+		`counter host_fs_stat_ops_count{mount="/data",result="error"} 1`,
+		`counter host_fs_stat_ops_count{mount="/data",result="ok"} 1`,
+	})
+}
+
+func TestFsTimeouts(t *testing.T) {
+	paths := testFsPaths(t)
+	const timeout = 100 * time.Millisecond
+	fc, err := newFsCollector(nil, &FsDiscoveryConfig{}, timeout, paths, nil)
+	if err != nil {
+		t.Fatalf("newFsCollector: %v", err)
+	}
+	fake := &testStatfs{
+		hang:    map[string]bool{paths.root("/nfs"): true, paths.root("/backup"): true},
+		release: make(chan struct{}),
+		calls:   map[string]int{},
+	}
+	fc.statfs = fake.statfs
+
+	// The hung mounts are waited on together, rather than one after another.
+	start := time.Now()
+	lines := gatherLines(t, fc, "host_fs_stat_ops_count")
+	if elapsed := time.Since(start); elapsed >= 2*timeout {
+		t.Errorf("Collect took %v with two hung mounts, want under %v", elapsed, 2*timeout)
+	}
+	checkLines(t, lines, []string{
+		`counter host_fs_stat_ops_count{mount="/",result="ok"} 1`,
+		`counter host_fs_stat_ops_count{mount="/backup",result="timeout"} 1`,
+		`counter host_fs_stat_ops_count{mount="/data",result="ok"} 1`,
+		`counter host_fs_stat_ops_count{mount="/nfs",result="timeout"} 1`,
+	})
+
+	// Once the hung calls return, their results are used rather than making
+	// further calls.
+	close(fake.release)
+	checkLines(t, gatherLines(t, fc, "host_fs_size_bytes"), []string{
+		`gauge host_fs_size_bytes{device="/dev/sda1",fstype="ext4",mount="/"} 4096000`,
+		`gauge host_fs_size_bytes{device="/dev/sda2",fstype="ext4",mount="/data"} 4096000`,
+		`gauge host_fs_size_bytes{device="server:/backup",fstype="nfs",mount="/backup"} 4096000`,
+		`gauge host_fs_size_bytes{device="server:/export",fstype="nfs",mount="/nfs"} 4096000`,
+	})
+	if n := fake.calls[paths.root("/nfs")]; n != 1 {
+		t.Errorf("statfs called %d times on hung mount, want 1", n)
+	}
+}
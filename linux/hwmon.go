@@ -0,0 +1,205 @@
+package linux
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the sysfs root.
+	hwmonPath   = "class/hwmon"
+	thermalPath = "class/thermal"
+	// Prefix of thermal zone directories in thermalPath.
+	thermalZonePrefix = "thermal_zone"
+)
+
+var (
+	// Matches hwmon sensor value files, e.g "temp1_input". Submatches are the
+	// sensor type, the sensor (e.g "temp1"), and the file's suffix.
+	hwmonSensorRe = regexp.MustCompile(`^((temp|fan|in|power)\d+)_(input|average)$`)
+	// hwmon sensor types, and the options for the metrics exported from them.
+	// scale converts the sysfs value to the metric's unit.
+	hwmonSensorTypes = map[string]struct {
+		opts  promm.GaugeOpts
+		scale float64
+	}{
+		"temp":  {promm.GaugeOpts{Name: "hwmon_temp_celsius", Help: "Temperature by hardware monitoring sensor (degrees Celsius)."}, 0.001},
+		"fan":   {promm.GaugeOpts{Name: "hwmon_fan_rpm", Help: "Fan speed by hardware monitoring sensor (revolutions per minute)."}, 1},
+		"in":    {promm.GaugeOpts{Name: "hwmon_voltage_volts", Help: "Voltage by hardware monitoring sensor (volts)."}, 0.001},
+		"power": {promm.GaugeOpts{Name: "hwmon_power_watts", Help: "Power by hardware monitoring sensor (watts)."}, 0.000001},
+	}
+)
+
+type hwmonCollector struct {
+	metrics util.MetricCollection
+	paths   sysPaths
+	// Metrics by sensor type, as in hwmonSensorTypes.
+	sensors map[string]*promm.GaugeVec
+}
+
+func newHwmonCollector(paths sysPaths, labels promm.Labels) *hwmonCollector {
+	hc := &hwmonCollector{
+		paths:   paths,
+		sensors: make(map[string]*promm.GaugeVec, len(hwmonSensorTypes)),
+	}
+	for sensorType, st := range hwmonSensorTypes {
+		opts := st.opts
+		opts.Namespace = namespace
+		opts.ConstLabels = labels
+		hc.sensors[sensorType] = hc.metrics.NewGaugeVec(opts, []string{"chip", "device", "sensor"})
+	}
+	return hc
+}
+
+func (hc *hwmonCollector) Describe(ch chan<- *promm.Desc) {
+	hc.metrics.Describe(ch)
+}
+
+func (hc *hwmonCollector) Collect(ch chan<- promm.Metric) {
+	if err := hc.readStats(); err != nil {
+		log.Printf("Error reading hardware monitoring sensors: %v", err)
+	}
+	hc.metrics.Collect(ch)
+}
+
+func (hc *hwmonCollector) readStats() error {
+	entries, err := ioutil.ReadDir(hc.paths.sys(hwmonPath))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		hc.readChip(hc.paths.sys(hwmonPath, entry.Name()))
+	}
+	return nil
+}
+
+// Reads the sensors of one hwmon device directory, e.g /sys/class/hwmon/hwmon0.
+func (hc *hwmonCollector) readChip(hwmonDir string) {
+	// Older drivers put their attributes in the device directory rather than
+	// the hwmon directory.
+	chipDir := hwmonDir
+	if _, err := os.Stat(filepath.Join(chipDir, "name")); err != nil {
+		chipDir = filepath.Join(hwmonDir, "device")
+	}
+	chip, err := readStringFile(filepath.Join(chipDir, "name"))
+	if err != nil {
+		log.Printf("Unable to read hwmon chip name in %q: %v", hwmonDir, err)
+		return
+	}
+	// Several chips can share a name (e.g one "nvme" per drive), so they are
+	// distinguished by the device that they belong to, where there is one.
+	var device string
+	if devicePath, err := filepath.EvalSymlinks(filepath.Join(hwmonDir, "device")); err == nil {
+		device = filepath.Base(devicePath)
+	}
+
+	entries, err := ioutil.ReadDir(chipDir)
+	if err != nil {
+		log.Printf("Unable to list hwmon sensors in %q: %v", chipDir, err)
+		return
+	}
+	// Sensor value files by sensor, preferring instantaneous values ("_input")
+	// over averages.
+	valueFiles := map[string]string{}
+	for _, entry := range entries {
+		m := hwmonSensorRe.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		if _, ok := valueFiles[m[1]]; ok && m[3] != "input" {
+			continue
+		}
+		valueFiles[m[1]] = entry.Name()
+	}
+
+	sensors := make([]string, 0, len(valueFiles))
+	for sensor := range valueFiles {
+		sensors = append(sensors, sensor)
+	}
+	sort.Strings(sensors)
+
+	// Sensor labels already used by this chip, to avoid exporting duplicate
+	// series if two sensors have the same *_label.
+	used := map[string]bool{}
+	for _, sensor := range sensors {
+		valueFile := valueFiles[sensor]
+		m := hwmonSensorRe.FindStringSubmatch(valueFile)
+		sensorType := m[2]
+		value, err := readIntFile(filepath.Join(chipDir, valueFile))
+		if err != nil {
+			// Sensors that are not connected commonly fail to read.
+			continue
+		}
+		name := sensor
+		if label, err := readStringFile(filepath.Join(chipDir, sensor+"_label")); err == nil && label != "" && !used[label] {
+			name = label
+		}
+		used[name] = true
+		hc.sensors[sensorType].With(promm.Labels{"chip": chip, "device": device, "sensor": name}).
+			Set(float64(value) * hwmonSensorTypes[sensorType].scale)
+	}
+}
+
+type thermalCollector struct {
+	metrics util.MetricCollection
+	paths   sysPaths
+	temp    *promm.GaugeVec
+}
+
+func newThermalCollector(paths sysPaths, labels promm.Labels) *thermalCollector {
+	tc := &thermalCollector{paths: paths}
+	tc.temp = tc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "thermal_zone_temp_celsius",
+			Help:        "Temperature by thermal zone (degrees Celsius).",
+			ConstLabels: labels,
+		},
+		[]string{"zone", "type"},
+	)
+	return tc
+}
+
+func (tc *thermalCollector) Describe(ch chan<- *promm.Desc) {
+	tc.metrics.Describe(ch)
+}
+
+func (tc *thermalCollector) Collect(ch chan<- promm.Metric) {
+	if err := tc.readStats(); err != nil {
+		log.Printf("Error reading thermal zones: %v", err)
+	}
+	tc.metrics.Collect(ch)
+}
+
+func (tc *thermalCollector) readStats() error {
+	entries, err := ioutil.ReadDir(tc.paths.sys(thermalPath))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), thermalZonePrefix) {
+			continue
+		}
+		zoneDir := tc.paths.sys(thermalPath, entry.Name())
+		zoneType, err := readStringFile(filepath.Join(zoneDir, "type"))
+		if err != nil {
+			log.Printf("Unable to read thermal zone type in %q: %v", zoneDir, err)
+			continue
+		}
+		// temp is in millidegrees Celsius.
+		temp, err := readIntFile(filepath.Join(zoneDir, "temp"))
+		if err != nil {
+			continue
+		}
+		labels := promm.Labels{"zone": strings.TrimPrefix(entry.Name(), thermalZonePrefix), "type": zoneType}
+		tc.temp.With(labels).Set(float64(temp) / 1000)
+	}
+	return nil
+}
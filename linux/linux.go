@@ -1,123 +1,207 @@
 package linux
 
 import (
-	"log"
-	"net"
-	"path/filepath"
-	"syscall"
-
 	"github.com/huin/warren/util"
 	promm "github.com/prometheus/client_golang/prometheus"
 )
 
 const (
-	namespace           = "host"
-	netPathSysClassNet  = "/sys/class/net"
-	netPathStatsTxBytes = "statistics/tx_bytes"
-	netPathStatsRxBytes = "statistics/rx_bytes"
+	namespace       = "host"
+	defaultProcRoot = "/proc"
+	defaultSysRoot  = "/sys"
+	defaultRootFs   = "/"
 )
 
 type Config struct {
+	// Where procfs and sysfs are mounted, e.g when running in a container
+	// with the host's filesystems mounted elsewhere. Default to "/proc" and
+	// "/sys".
+	ProcRoot string `toml:"proc_root"`
+	SysRoot  string `toml:"sys_root"`
+	// Where the host's root filesystem is mounted, e.g "/host" in a container.
+	// Filesystems and discovered mount points are stat'ed beneath it, but
+	// labelled with their own paths. Defaults to "/".
+	RootFs      string `toml:"root_fs"`
 	Filesystems []string
-	Cpu         CpuConfig
-	Labels      promm.Labels
+	// Discover mounted filesystems to export from /proc/self/mountinfo, in
+	// addition to those in Filesystems, if set.
+	FsDiscovery *FsDiscoveryConfig `toml:"fs_discovery"`
+	// Timeout for the statfs calls, which are made in parallel. Defaults to 5
+	// seconds.
+	FsTimeout util.Duration `toml:"fs_timeout"`
+	Cpu       CpuConfig
+	Memory    MemoryConfig
+	VmStat    VmStatConfig `toml:"vmstat"`
+	Net       NetConfig
+	// Export kernel activity counters from /proc/stat (context switches,
+	// interrupts, forks, boot time, running and blocked processes).
+	Kernel bool
+	// Export load averages and task counts from /proc/loadavg.
+	LoadAvg bool
+	// Export uptime and idle time from /proc/uptime.
+	Uptime bool
+	// Export file handle counts from /proc/sys/fs/file-nr.
+	FileNr bool `toml:"file_nr"`
+	// Export the kernel's available entropy.
+	Entropy bool
+	// Export netfilter connection tracking table usage.
+	Conntrack bool
+	// Export temperature, fan, voltage and power sensors from
+	// /sys/class/hwmon.
+	Hwmon bool
+	// Export thermal zone temperatures from /sys/class/thermal.
+	Thermal bool
+	// Export batteries, UPSes and mains supplies from /sys/class/power_supply.
+	PowerSupply bool `toml:"power_supply"`
+	// Export 1-Wire and IIO environmental sensors, if set.
+	Sensors *SensorsConfig
+	// Export pressure stall information from /proc/pressure.
+	Pressure bool
+	// Export resource usage by cgroup from the cgroup v2 hierarchy, if set.
+	Cgroups *CgroupConfig
+	// Groups of processes to export resource usage for.
+	ProcessGroups []ProcessGroupConfig `toml:"process_group"`
+	// Export network protocol statistics from /proc/net/snmp, netstat and
+	// sockstat, if set.
+	NetStat *NetStatConfig `toml:"netstat"`
+	// Export wireless link quality from /proc/net/wireless, and optionally
+	// connected stations, if set.
+	Wireless *WirelessConfig
+	// Export the kernel's clock synchronisation state from adjtimex, and
+	// optionally a time sync daemon's state, if set.
+	TimeSync *TimeSyncConfig `toml:"time_sync"`
+	// Export software RAID array status from /proc/mdstat.
+	MdStat bool `toml:"mdstat"`
+	// Export ZFS ARC statistics and pool states from /proc/spl/kstat/zfs.
+	Zfs bool
+	// Export block device I/O statistics from /proc/diskstats, if set.
+	DiskStats *DiskStatsConfig
+	Labels    promm.Labels
 }
 
 type Collector struct {
 	cfg     Config
 	metrics util.MetricCollection
-	// Meta-metrics:
-	fsStatOps *promm.CounterVec
-	// Filesystem metrics:
-	fsSizeBytes       *promm.GaugeVec
-	fsFreeBytes       *promm.GaugeVec
-	fsUnprivFreeBytes *promm.GaugeVec
-	fsFiles           *promm.GaugeVec
-	fsFilesFree       *promm.GaugeVec
-	// Network metrics:
-	ifaceTxBytes *promm.GaugeVec
-	ifaceRxBytes *promm.GaugeVec
 }
 
 func New(cfg Config) (*Collector, error) {
-	fsLabelNames := []string{"mount"}
+	paths := sysPaths{procRoot: cfg.ProcRoot, sysRoot: cfg.SysRoot, rootFs: cfg.RootFs}
+	if paths.procRoot == "" {
+		paths.procRoot = defaultProcRoot
+	}
+	if paths.sysRoot == "" {
+		paths.sysRoot = defaultSysRoot
+	}
+	if paths.rootFs == "" {
+		paths.rootFs = defaultRootFs
+	}
 	var metrics util.MetricCollection
-	if cpuCollector, err := newCpuCollector(cfg.Cpu, cfg.Labels); err != nil {
+	if fsCollector, err := newFsCollector(cfg.Filesystems, cfg.FsDiscovery, cfg.FsTimeout.Duration, paths, cfg.Labels); err != nil {
+		return nil, err
+	} else {
+		metrics.Add(fsCollector)
+	}
+	if cpuCollector, err := newCpuCollector(cfg.Cpu, cfg.Kernel, paths, cfg.Labels); err != nil {
 		return nil, err
 	} else {
 		metrics.Add(cpuCollector)
 	}
+	if cfg.Cpu.Frequency || cfg.Cpu.Throttle {
+		metrics.Add(newCpuFreqCollector(cfg.Cpu, paths, cfg.Labels))
+	}
+	if memCollector, err := newMemCollector(cfg.Memory, paths, cfg.Labels); err != nil {
+		return nil, err
+	} else {
+		metrics.Add(memCollector)
+	}
+	if netCollector, err := newNetCollector(cfg.Net, paths, cfg.Labels); err != nil {
+		return nil, err
+	} else {
+		metrics.Add(netCollector)
+	}
+	if vmStatCollector, err := newVmStatCollector(cfg.VmStat, paths, cfg.Labels); err != nil {
+		return nil, err
+	} else {
+		metrics.Add(vmStatCollector)
+	}
+	if cfg.LoadAvg {
+		metrics.Add(newLoadCollector(paths, cfg.Labels))
+	}
+	if cfg.Uptime {
+		metrics.Add(newUptimeCollector(paths, cfg.Labels))
+	}
+	if cfg.FileNr {
+		metrics.Add(newFileNrCollector(paths, cfg.Labels))
+	}
+	if cfg.Entropy {
+		metrics.Add(newEntropyCollector(paths, cfg.Labels))
+	}
+	if cfg.Conntrack {
+		metrics.Add(newConntrackCollector(paths, cfg.Labels))
+	}
+	if cfg.Hwmon {
+		metrics.Add(newHwmonCollector(paths, cfg.Labels))
+	}
+	if cfg.Thermal {
+		metrics.Add(newThermalCollector(paths, cfg.Labels))
+	}
+	if cfg.PowerSupply {
+		metrics.Add(newPowerSupplyCollector(paths, cfg.Labels))
+	}
+	if cfg.Sensors != nil {
+		metrics.Add(newSensorsCollector(*cfg.Sensors, paths, cfg.Labels))
+	}
+	if cfg.Pressure {
+		metrics.Add(newPressureCollector(paths, cfg.Labels))
+	}
+	if cfg.Cgroups != nil {
+		if cgroupCollector, err := newCgroupCollector(*cfg.Cgroups, paths, cfg.Labels); err != nil {
+			return nil, err
+		} else {
+			metrics.Add(cgroupCollector)
+		}
+	}
+	if len(cfg.ProcessGroups) > 0 {
+		if processCollector, err := newProcessCollector(cfg.ProcessGroups, jiffySeconds(paths, cfg.Cpu.ClockTicks), paths, cfg.Labels); err != nil {
+			return nil, err
+		} else {
+			metrics.Add(processCollector)
+		}
+	}
+	if cfg.NetStat != nil {
+		if netStatCollector, err := newNetStatCollector(*cfg.NetStat, paths, cfg.Labels); err != nil {
+			return nil, err
+		} else {
+			metrics.Add(netStatCollector)
+		}
+	}
+	if cfg.Wireless != nil {
+		metrics.Add(newWirelessCollector(*cfg.Wireless, paths, cfg.Labels))
+	}
+	if cfg.TimeSync != nil {
+		if timeSyncCollector, err := newTimeSyncCollector(*cfg.TimeSync, cfg.Labels); err != nil {
+			return nil, err
+		} else {
+			metrics.Add(timeSyncCollector)
+		}
+	}
+	if cfg.MdStat {
+		metrics.Add(newMdCollector(paths, cfg.Labels))
+	}
+	if cfg.Zfs {
+		metrics.Add(newZfsCollector(paths, cfg.Labels))
+	}
+	if cfg.DiskStats != nil {
+		if diskStatsCollector, err := newDiskStatsCollector(*cfg.DiskStats, paths, cfg.Labels); err != nil {
+			return nil, err
+		} else {
+			metrics.Add(diskStatsCollector)
+		}
+	}
 	lc := &Collector{
-		cfg: cfg,
-		// Meta-metrics:
-		fsStatOps: metrics.NewCounterVec(
-			promm.CounterOpts{
-				Namespace: namespace, Name: "fs_stat_ops_count",
-				Help:        "Statfs calls by mount and result (call count).",
-				ConstLabels: cfg.Labels,
-			},
-			[]string{"mount", "result"},
-		),
-		// Filesystem metrics:
-		fsSizeBytes: metrics.NewGaugeVec(
-			promm.GaugeOpts{
-				Namespace: namespace, Name: "fs_size_bytes",
-				Help:        "Filesystem capacity (bytes).",
-				ConstLabels: cfg.Labels,
-			},
-			fsLabelNames,
-		),
-		fsFreeBytes: metrics.NewGaugeVec(
-			promm.GaugeOpts{
-				Namespace: namespace, Name: "fs_free_bytes",
-				Help:        "Filesystem free space (bytes).",
-				ConstLabels: cfg.Labels,
-			},
-			fsLabelNames,
-		),
-		fsUnprivFreeBytes: metrics.NewGaugeVec(
-			promm.GaugeOpts{
-				Namespace: namespace, Name: "fs_unpriv_free_bytes",
-				Help:        "Filesystem unpriviledged free space (bytes).",
-				ConstLabels: cfg.Labels,
-			},
-			fsLabelNames,
-		),
-		fsFiles: metrics.NewGaugeVec(
-			promm.GaugeOpts{
-				Namespace: namespace, Name: "fs_files",
-				Help:        "File count (files).",
-				ConstLabels: cfg.Labels,
-			},
-			fsLabelNames,
-		),
-		fsFilesFree: metrics.NewGaugeVec(
-			promm.GaugeOpts{
-				Namespace: namespace, Name: "fs_free_files",
-				Help:        "File free count (files).",
-				ConstLabels: cfg.Labels,
-			},
-			fsLabelNames,
-		),
-		// Network metrics:
-		ifaceTxBytes: metrics.NewGaugeVec(
-			promm.GaugeOpts{
-				Namespace: namespace, Name: "net_tx_bytes",
-				Help:        "Count of bytes transmitted by network interface (bytes).",
-				ConstLabels: cfg.Labels,
-			},
-			[]string{"interface"},
-		),
-		ifaceRxBytes: metrics.NewGaugeVec(
-			promm.GaugeOpts{
-				Namespace: namespace, Name: "net_rx_bytes",
-				Help:        "Count of bytes received by network interface (bytes).",
-				ConstLabels: cfg.Labels,
-			},
-			[]string{"interface"},
-		),
-	}
-	lc.metrics = metrics
+		cfg:     cfg,
+		metrics: metrics,
+	}
 	return lc, nil
 }
 
@@ -126,36 +210,5 @@ func (lc *Collector) Describe(ch chan<- *promm.Desc) {
 }
 
 func (lc *Collector) Collect(ch chan<- promm.Metric) {
-	// Filesystems
-	for _, fs := range lc.cfg.Filesystems {
-		var stat syscall.Statfs_t
-		if err := syscall.Statfs(fs, &stat); err != nil {
-			log.Printf("Error stating filesystem %q: %v", fs, err)
-			lc.fsStatOps.With(promm.Labels{"mount": fs, "result": "error"}).Inc()
-			continue
-		}
-		lc.fsStatOps.With(promm.Labels{"mount": fs, "result": "ok"}).Inc()
-		mountLabels := promm.Labels{"mount": fs}
-		bs := uint64(stat.Bsize)
-		lc.fsSizeBytes.With(mountLabels).Set(float64(bs * stat.Blocks))
-		lc.fsFreeBytes.With(mountLabels).Set(float64(bs * stat.Bfree))
-		lc.fsUnprivFreeBytes.With(mountLabels).Set(float64(bs * stat.Bavail))
-		lc.fsFiles.With(mountLabels).Set(float64(stat.Files))
-		lc.fsFilesFree.With(mountLabels).Set(float64(stat.Ffree))
-	}
-	// Networks
-	if ifaces, err := net.Interfaces(); err != nil {
-		log.Print("Error getting network interfaces: ", err)
-	} else {
-		for _, iface := range ifaces {
-			readIntFileIntoGauge(
-				lc.ifaceTxBytes.With(promm.Labels{"interface": iface.Name}),
-				filepath.Join(netPathSysClassNet, iface.Name, netPathStatsTxBytes))
-			readIntFileIntoGauge(
-				lc.ifaceRxBytes.With(promm.Labels{"interface": iface.Name}),
-				filepath.Join(netPathSysClassNet, iface.Name, netPathStatsRxBytes))
-		}
-	}
-
 	lc.metrics.Collect(ch)
 }
@@ -0,0 +1,225 @@
+package linux
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	promm "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Fixture procfs and sysfs trees, used via Config.ProcRoot and SysRoot.
+const (
+	testProcRoot = "testdata/proc"
+	testSysRoot  = "testdata/sys"
+)
+
+var testPaths = sysPaths{procRoot: testProcRoot, sysRoot: testSysRoot}
+
+// Gathers the metrics exported by c through a registry, and returns those whose
+// names start with prefix as sorted "name{labels} value" strings.
+func gatherLines(t *testing.T, c promm.Collector, prefix string) []string {
+	t.Helper()
+	reg := promm.NewPedanticRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	var lines []string
+	for _, mf := range mfs {
+		if !strings.HasPrefix(mf.GetName(), prefix) {
+			continue
+		}
+		for _, m := range mf.Metric {
+			lines = append(lines, formatMetric(mf, m))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func formatMetric(mf *dto.MetricFamily, m *dto.Metric) string {
+	var labels []string
+	for _, lp := range m.Label {
+		labels = append(labels, fmt.Sprintf("%s=%q", lp.GetName(), lp.GetValue()))
+	}
+	var value float64
+	switch mf.GetType() {
+	case dto.MetricType_GAUGE:
+		value = m.GetGauge().GetValue()
+	case dto.MetricType_COUNTER:
+		value = m.GetCounter().GetValue()
+	case dto.MetricType_UNTYPED:
+		value = m.GetUntyped().GetValue()
+	}
+	return fmt.Sprintf("%s %s{%s} %.15g", strings.ToLower(mf.GetType().String()),
+		mf.GetName(), strings.Join(labels, ","), value)
+}
+
+func checkLines(t *testing.T, got, want []string) {
+	t.Helper()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got metrics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// Collects from c in several goroutines at once. Run with -race to check that
+// Collect is safe for concurrent use.
+func collectConcurrently(c promm.Collector) {
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ch := make(chan promm.Metric)
+			go func() {
+				c.Collect(ch)
+				close(ch)
+			}()
+			for range ch {
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func newTestCollector(t *testing.T, cfg Config) *Collector {
+	t.Helper()
+	cfg.ProcRoot = testProcRoot
+	cfg.SysRoot = testSysRoot
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return c
+}
+
+func TestCpuStats(t *testing.T) {
+	c := newTestCollector(t, Config{
+		Cpu: CpuConfig{
+			States:     []string{"user", "idle"},
+			Combined:   true,
+			ByCore:     true,
+			ClockTicks: 100,
+		},
+		Kernel: true,
+		Net:    NetConfig{Include: "^$"},
+	})
+	checkLines(t, gatherLines(t, c, "host_"), []string{
+		`counter host_context_switches{} 2870519`,
+		`counter host_cpu_by_core_seconds{core="cpu0",state="idle"} 133432.92`,
+		`counter host_cpu_by_core_seconds{core="cpu0",state="user"} 13932.8`,
+		`counter host_cpu_by_core_seconds{core="cpu1",state="idle"} 133504.4`,
+		`counter host_cpu_by_core_seconds{core="cpu1",state="user"} 13353`,
+		`counter host_cpu_combined_seconds{state="idle"} 468284.83`,
+		`counter host_cpu_combined_seconds{state="user"} 101321.53`,
+		`counter host_forks{} 29640`,
+		`counter host_interrupts{} 1462898`,
+		`gauge host_boot_time_seconds{} 1700000000`,
+		`gauge host_procs_blocked{} 1`,
+		`gauge host_procs_running{} 3`,
+	})
+}
+
+func TestCpuStatsConcurrent(t *testing.T) {
+	c := newTestCollector(t, Config{
+		Cpu: CpuConfig{
+			States:     []string{"user", "idle"},
+			Combined:   true,
+			ByCore:     true,
+			ClockTicks: 100,
+		},
+		Net: NetConfig{Include: "^$"},
+	})
+	collectConcurrently(c)
+}
+
+func TestMemInfo(t *testing.T) {
+	c := newTestCollector(t, Config{
+		Memory: MemoryConfig{Fields: []string{"MemTotal", "MemAvailable", "SwapFree", "HugePages_Total", "Hugepagesize", "Dirty"}},
+		Net:    NetConfig{Include: "^$"},
+	})
+	// Dirty is absent from the fixture, so is not exported.
+	checkLines(t, gatherLines(t, c, "host_memory_"), []string{
+		`gauge host_memory_bytes{field="Hugepagesize"} 2097152`,
+		`gauge host_memory_bytes{field="MemAvailable"} 10059214848`,
+		`gauge host_memory_bytes{field="MemTotal"} 16705712128`,
+		`gauge host_memory_bytes{field="SwapFree"} 2147479552`,
+		`gauge host_memory_hugepages{field="HugePages_Total"} 4`,
+	})
+}
+
+func TestMemInfoUnknownField(t *testing.T) {
+	if _, err := New(Config{Memory: MemoryConfig{Fields: []string{"MemTotl"}}}); err == nil {
+		t.Error("New succeeded with unknown memory field")
+	}
+}
+
+func TestNetInterfaces(t *testing.T) {
+	c := newTestCollector(t, Config{
+		Net: NetConfig{
+			Exclude:    "^lo$",
+			Statistics: []string{"rx_bytes", "tx_bytes", "rx_nohandler"},
+		},
+	})
+	// wlan0 is down, so has no carrier or speed. bonding_masters is not an
+	// interface. No interface has rx_nohandler, as on older kernels.
+	want := []string{
+		`counter host_net_rx_bytes{interface="eth0"} 1000`,
+		`counter host_net_rx_bytes{interface="wlan0"} 300`,
+		`counter host_net_tx_bytes{interface="eth0"} 2000`,
+		`counter host_net_tx_bytes{interface="wlan0"} 400`,
+		`gauge host_net_carrier{interface="eth0"} 1`,
+		`gauge host_net_mtu_bytes{interface="eth0"} 1500`,
+		`gauge host_net_mtu_bytes{interface="wlan0"} 1500`,
+		`gauge host_net_operstate{interface="eth0",operstate="up"} 1`,
+		`gauge host_net_operstate{interface="wlan0",operstate="down"} 1`,
+		`gauge host_net_speed_bytes{interface="eth0"} 125000000`,
+	}
+	checkLines(t, gatherLines(t, c, "host_net_"), want)
+	// A second collection gives the same result.
+	checkLines(t, gatherLines(t, c, "host_net_"), want)
+}
+
+func TestNetInterfaceRemoved(t *testing.T) {
+	paths := sysPaths{sysRoot: t.TempDir()}
+	vethDir := paths.sys(netPathSysClassNet, "veth0")
+	for path, data := range map[string]string{
+		filepath.Join(vethDir, netPathStatistics, "rx_bytes"): "100\n",
+		filepath.Join(vethDir, netPathOperState):              "up\n",
+		filepath.Join(vethDir, netPathMTU):                    "1500\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nc, err := newNetCollector(NetConfig{Statistics: []string{"rx_bytes"}}, paths, nil)
+	if err != nil {
+		t.Fatalf("newNetCollector: %v", err)
+	}
+	checkLines(t, gatherLines(t, nc, "host_net_"), []string{
+		`counter host_net_rx_bytes{interface="veth0"} 100`,
+		`gauge host_net_mtu_bytes{interface="veth0"} 1500`,
+		`gauge host_net_operstate{interface="veth0",operstate="up"} 1`,
+	})
+
+	if err := os.RemoveAll(vethDir); err != nil {
+		t.Fatal(err)
+	}
+	checkLines(t, gatherLines(t, nc, "host_net_"), nil)
+	if len(nc.operStates) != 0 {
+		t.Errorf("operStates = %v after the interface was removed, want empty", nc.operStates)
+	}
+}
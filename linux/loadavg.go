@@ -0,0 +1,159 @@
+package linux
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the procfs root.
+	loadAvgPath = "loadavg"
+	uptimePath  = "uptime"
+)
+
+type loadCollector struct {
+	metrics       util.MetricCollection
+	paths         sysPaths
+	load1         promm.Gauge
+	load5         promm.Gauge
+	load15        promm.Gauge
+	tasksRunnable promm.Gauge
+	tasksTotal    promm.Gauge
+}
+
+func newLoadCollector(paths sysPaths, labels promm.Labels) *loadCollector {
+	lc := &loadCollector{paths: paths}
+	lc.load1 = lc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "load1",
+		Help:        "System load average over 1 minute.",
+		ConstLabels: labels,
+	})
+	lc.load5 = lc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "load5",
+		Help:        "System load average over 5 minutes.",
+		ConstLabels: labels,
+	})
+	lc.load15 = lc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "load15",
+		Help:        "System load average over 15 minutes.",
+		ConstLabels: labels,
+	})
+	lc.tasksRunnable = lc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "tasks_runnable",
+		Help:        "Currently runnable processes and threads (count).",
+		ConstLabels: labels,
+	})
+	lc.tasksTotal = lc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "tasks",
+		Help:        "Processes and threads that currently exist (count).",
+		ConstLabels: labels,
+	})
+	return lc
+}
+
+func (lc *loadCollector) Describe(ch chan<- *promm.Desc) {
+	lc.metrics.Describe(ch)
+}
+
+func (lc *loadCollector) Collect(ch chan<- promm.Metric) {
+	if err := lc.readStats(); err != nil {
+		log.Printf("Error reading load average: %v", err)
+	}
+	lc.metrics.Collect(ch)
+}
+
+func (lc *loadCollector) readStats() error {
+	// Of the form "0.20 0.18 0.12 1/80 11206".
+	values, err := readFields(lc.paths.proc(loadAvgPath), 4)
+	if err != nil {
+		return err
+	}
+	for i, g := range []promm.Gauge{lc.load1, lc.load5, lc.load15} {
+		load, err := strconv.ParseFloat(values[i], 64)
+		if err != nil {
+			return err
+		}
+		g.Set(load)
+	}
+	tasks := strings.SplitN(values[3], "/", 2)
+	if len(tasks) != 2 {
+		return fmt.Errorf("malformed tasks field %q", values[3])
+	}
+	for i, g := range []promm.Gauge{lc.tasksRunnable, lc.tasksTotal} {
+		count, err := strconv.ParseUint(tasks[i], 10, 64)
+		if err != nil {
+			return err
+		}
+		g.Set(float64(count))
+	}
+	return nil
+}
+
+type uptimeCollector struct {
+	metrics util.MetricCollection
+	paths   sysPaths
+	uptime  promm.Gauge
+	idle    promm.Gauge
+}
+
+func newUptimeCollector(paths sysPaths, labels promm.Labels) *uptimeCollector {
+	uc := &uptimeCollector{paths: paths}
+	uc.uptime = uc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "uptime_seconds",
+		Help:        "Time since the system booted (seconds).",
+		ConstLabels: labels,
+	})
+	uc.idle = uc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "idle_seconds",
+		Help:        "Time spent idle since the system booted, summed over all cores (seconds).",
+		ConstLabels: labels,
+	})
+	return uc
+}
+
+func (uc *uptimeCollector) Describe(ch chan<- *promm.Desc) {
+	uc.metrics.Describe(ch)
+}
+
+func (uc *uptimeCollector) Collect(ch chan<- promm.Metric) {
+	if err := uc.readStats(); err != nil {
+		log.Printf("Error reading uptime: %v", err)
+	}
+	uc.metrics.Collect(ch)
+}
+
+func (uc *uptimeCollector) readStats() error {
+	// Of the form "350735.47 234388.90".
+	values, err := readFields(uc.paths.proc(uptimePath), 2)
+	if err != nil {
+		return err
+	}
+	for i, g := range []promm.Gauge{uc.uptime, uc.idle} {
+		seconds, err := strconv.ParseFloat(values[i], 64)
+		if err != nil {
+			return err
+		}
+		g.Set(seconds)
+	}
+	return nil
+}
+
+// Read the whitespace separated fields of a short text file, requiring at least
+// min fields.
+func readFields(path string, min int) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := strings.Fields(string(data))
+	if len(values) < min {
+		return nil, fmt.Errorf("expected at least %d fields in %q, got %d", min, path, len(values))
+	}
+	return values, nil
+}
@@ -0,0 +1,191 @@
+package linux
+
+import (
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the procfs root.
+	mdStatPath = "mdstat"
+	// Value of the "action" label of md_sync_completed_ratio when an array is
+	// not syncing.
+	mdActionIdle = "idle"
+)
+
+var (
+	// Matches array header lines, e.g:
+	// md127 : active raid1 sdb1[1] sda1[0](F)
+	mdArrayRe = regexp.MustCompile(`^(md\S*) : (\S+)( \((?:auto-)?read-only\))?(.*)$`)
+	// Matches a member disk and its flags, e.g "sda1[0](F)".
+	mdDiskRe = regexp.MustCompile(`^\S+\[\d+\]((?:\([A-Z]\))*)$`)
+	// Matches the size and disk status of an array, e.g
+	// "976630464 blocks super 1.2 [2/1] [U_]". The disk counts are absent for
+	// some levels (e.g raid0).
+	mdBlocksRe = regexp.MustCompile(`^(\d+) blocks.*?(?: \[(\d+)/(\d+)\])?(?: \[[U_]+\])?$`)
+	// Matches sync progress, e.g "recovery =  8.6% (84423744/976630464)", or
+	// delayed syncs, e.g "resync=DELAYED".
+	mdSyncRe = regexp.MustCompile(`(resync|recovery|reshape|check|repair)\s*=\s*(?:[\d.]+% \((\d+)/(\d+)\)|(DELAYED|PENDING))`)
+)
+
+type mdCollector struct {
+	metrics util.MetricCollection
+	paths   sysPaths
+	state   *promm.GaugeVec
+	size    *promm.GaugeVec
+	disks   *promm.GaugeVec
+	sync    *promm.GaugeVec
+
+	// Held by Collect, as readStats resets the array metrics before refilling.
+	mu sync.Mutex
+}
+
+func newMdCollector(paths sysPaths, labels promm.Labels) *mdCollector {
+	mc := &mdCollector{paths: paths}
+	mc.state = mc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "md_state",
+			Help:        "State of software RAID array, 1 for the current state.",
+			ConstLabels: labels,
+		},
+		[]string{"array", "state", "level"},
+	)
+	mc.size = mc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "md_size_bytes",
+			Help:        "Size of software RAID array (bytes).",
+			ConstLabels: labels,
+		},
+		[]string{"array"},
+	)
+	mc.disks = mc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "md_disks",
+			Help:        "Disks of software RAID array by state, where \"required\" is the number the array should have (disks).",
+			ConstLabels: labels,
+		},
+		[]string{"array", "state"},
+	)
+	mc.sync = mc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "md_sync_completed_ratio",
+			Help:        "Progress of software RAID array sync action, 1 when idle (ratio).",
+			ConstLabels: labels,
+		},
+		[]string{"array", "action"},
+	)
+	return mc
+}
+
+func (mc *mdCollector) Describe(ch chan<- *promm.Desc) {
+	mc.metrics.Describe(ch)
+}
+
+func (mc *mdCollector) Collect(ch chan<- promm.Metric) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if err := mc.readStats(); err != nil {
+		log.Printf("Error reading software RAID status: %v", err)
+	}
+	mc.metrics.Collect(ch)
+}
+
+func (mc *mdCollector) readStats() error {
+	data, err := ioutil.ReadFile(mc.paths.proc(mdStatPath))
+	if err != nil {
+		return err
+	}
+	// Arrays and their states come and go, so only export those in the
+	// current file.
+	mc.state.Reset()
+	mc.size.Reset()
+	mc.disks.Reset()
+	mc.sync.Reset()
+
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		m := mdArrayRe.FindStringSubmatch(lines[i])
+		if m == nil {
+			continue
+		}
+		array, state := m[1], m[2]
+		if m[3] != "" {
+			state = strings.Trim(m[3], " ()")
+		}
+		// The level is absent for inactive arrays.
+		var level string
+		var failed, spare int
+		for _, field := range strings.Fields(m[4]) {
+			dm := mdDiskRe.FindStringSubmatch(field)
+			if dm == nil {
+				if level == "" {
+					level = field
+				}
+				continue
+			}
+			switch {
+			case strings.Contains(dm[1], "(F)"):
+				failed++
+			case strings.Contains(dm[1], "(S)"):
+				spare++
+			}
+		}
+		mc.state.With(promm.Labels{"array": array, "state": state, "level": level}).Set(1)
+		mc.disks.With(promm.Labels{"array": array, "state": "failed"}).Set(float64(failed))
+		mc.disks.With(promm.Labels{"array": array, "state": "spare"}).Set(float64(spare))
+
+		// Status lines follow the header, up to a blank line.
+		action, ratio := mdActionIdle, 1.0
+		for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+			i++
+			line := strings.TrimSpace(lines[i])
+			if bm := mdBlocksRe.FindStringSubmatch(line); bm != nil {
+				if err := mc.exportBlocks(array, bm); err != nil {
+					log.Printf("Unable to parse %q for array %s: %v", line, array, err)
+				}
+			} else if sm := mdSyncRe.FindStringSubmatch(line); sm != nil {
+				action, ratio = sm[1], 0
+				if sm[4] == "" {
+					done, _ := strconv.ParseFloat(sm[2], 64)
+					total, _ := strconv.ParseFloat(sm[3], 64)
+					if total > 0 {
+						ratio = done / total
+					}
+				}
+			}
+		}
+		mc.sync.With(promm.Labels{"array": array, "action": action}).Set(ratio)
+	}
+	return nil
+}
+
+// bm is a match of mdBlocksRe.
+func (mc *mdCollector) exportBlocks(array string, bm []string) error {
+	// Blocks are of 1 KiB.
+	blocks, err := strconv.ParseUint(bm[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	mc.size.With(promm.Labels{"array": array}).Set(float64(blocks) * 1024)
+	if bm[2] == "" {
+		return nil
+	}
+	required, err := strconv.ParseUint(bm[2], 10, 64)
+	if err != nil {
+		return err
+	}
+	active, err := strconv.ParseUint(bm[3], 10, 64)
+	if err != nil {
+		return err
+	}
+	mc.disks.With(promm.Labels{"array": array, "state": "required"}).Set(float64(required))
+	mc.disks.With(promm.Labels{"array": array, "state": "active"}).Set(float64(active))
+	return nil
+}
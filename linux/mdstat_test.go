@@ -0,0 +1,71 @@
+package linux
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMdStat(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want []string
+	}{
+		{"degraded", []string{
+			`gauge host_md_disks{array="md0",state="active"} 1`,
+			`gauge host_md_disks{array="md0",state="failed"} 1`,
+			`gauge host_md_disks{array="md0",state="required"} 2`,
+			`gauge host_md_disks{array="md0",state="spare"} 0`,
+			`gauge host_md_size_bytes{array="md0"} 1000069595136`,
+			`gauge host_md_state{array="md0",level="raid1",state="active"} 1`,
+			`gauge host_md_sync_completed_ratio{action="idle",array="md0"} 1`,
+		}},
+		{"recovering", []string{
+			`gauge host_md_disks{array="md1",state="active"} 3`,
+			`gauge host_md_disks{array="md1",state="failed"} 0`,
+			`gauge host_md_disks{array="md1",state="required"} 4`,
+			`gauge host_md_disks{array="md1",state="spare"} 1`,
+			`gauge host_md_disks{array="md2",state="active"} 2`,
+			`gauge host_md_disks{array="md2",state="failed"} 0`,
+			`gauge host_md_disks{array="md2",state="required"} 2`,
+			`gauge host_md_disks{array="md2",state="spare"} 0`,
+			`gauge host_md_size_bytes{array="md1"} 3000208195584`,
+			`gauge host_md_size_bytes{array="md2"} 106823680`,
+			`gauge host_md_state{array="md1",level="raid5",state="active"} 1`,
+			`gauge host_md_state{array="md2",level="raid1",state="auto-read-only"} 1`,
+			`gauge host_md_sync_completed_ratio{action="recovery",array="md1"} 0.08644391477556`,
+			`gauge host_md_sync_completed_ratio{action="resync",array="md2"} 0`,
+		}},
+		// raid0 arrays have no disk counts, and inactive arrays have no level.
+		{"raid0", []string{
+			`gauge host_md_disks{array="md126",state="failed"} 0`,
+			`gauge host_md_disks{array="md126",state="spare"} 1`,
+			`gauge host_md_disks{array="md127",state="failed"} 0`,
+			`gauge host_md_disks{array="md127",state="spare"} 0`,
+			`gauge host_md_size_bytes{array="md126"} 1000069619712`,
+			`gauge host_md_size_bytes{array="md127"} 2000138797056`,
+			`gauge host_md_state{array="md126",level="",state="inactive"} 1`,
+			`gauge host_md_state{array="md127",level="raid0",state="active"} 1`,
+			`gauge host_md_sync_completed_ratio{action="idle",array="md126"} 1`,
+			`gauge host_md_sync_completed_ratio{action="idle",array="md127"} 1`,
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			paths := sysPaths{procRoot: filepath.Join("testdata", "mdstat", tc.name)}
+			mc := newMdCollector(paths, nil)
+			checkLines(t, gatherLines(t, mc, "host_md_"), tc.want)
+		})
+	}
+}
+
+// Arrays that are no longer present are not exported.
+func TestMdStatArrayRemoved(t *testing.T) {
+	mc := newMdCollector(sysPaths{procRoot: filepath.Join("testdata", "mdstat", "degraded")}, nil)
+	gatherLines(t, mc, "host_md_")
+	mc.paths.procRoot = filepath.Join("testdata", "mdstat", "raid0")
+	for _, line := range gatherLines(t, mc, "host_md_") {
+		if strings.Contains(line, `array="md0"`) {
+			t.Errorf("removed array still exported: %s", line)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package linux
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the procfs root.
+	memInfoPath = "meminfo"
+)
+
+var (
+	// Fields in /proc/meminfo that can be exported. Not all fields are present
+	// in all kernel versions.
+	memFields = []string{
+		"MemTotal", "MemFree", "MemAvailable", "Buffers", "Cached", "SwapCached",
+		"Active", "Inactive", "Active(anon)", "Inactive(anon)", "Active(file)",
+		"Inactive(file)", "Unevictable", "Mlocked", "SwapTotal", "SwapFree",
+		"Dirty", "Writeback", "AnonPages", "Mapped", "Shmem", "KReclaimable",
+		"Slab", "SReclaimable", "SUnreclaim", "KernelStack", "PageTables",
+		"NFS_Unstable", "Bounce", "WritebackTmp", "CommitLimit", "Committed_AS",
+		"VmallocTotal", "VmallocUsed", "VmallocChunk", "Percpu",
+		"HardwareCorrupted", "AnonHugePages", "ShmemHugePages", "ShmemPmdMapped",
+		"FileHugePages", "FilePmdMapped", "CmaTotal", "CmaFree", "HugePages_Total",
+		"HugePages_Free", "HugePages_Rsvd", "HugePages_Surp", "Hugepagesize",
+		"Hugetlb", "DirectMap4k", "DirectMap2M", "DirectMap1G",
+	}
+)
+
+type MemoryConfig struct {
+	// /proc/meminfo fields to export values for. See the (private) memFields
+	// variable for allowed values.
+	Fields []string
+}
+
+type memCollector struct {
+	metrics util.MetricCollection
+	paths   sysPaths
+	// Fields with a size in kB, exported in bytes.
+	bytes *promm.GaugeVec
+	// Fields without a unit, i.e the HugePages_* page counts.
+	pages *promm.GaugeVec
+	// Fields to export, from MemoryConfig.Fields.
+	fields map[string]struct{}
+}
+
+func newMemCollector(cfg MemoryConfig, paths sysPaths, labels promm.Labels) (*memCollector, error) {
+	mc := &memCollector{
+		paths:  paths,
+		fields: make(map[string]struct{}, len(cfg.Fields)),
+	}
+
+	for _, field := range cfg.Fields {
+		found := false
+		for _, knownField := range memFields {
+			if knownField == field {
+				mc.fields[field] = struct{}{}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown memory field %q, accepted values: %s",
+				field, strings.Join(memFields, ", "))
+		}
+	}
+
+	if len(mc.fields) > 0 {
+		mc.bytes = mc.metrics.NewGaugeVec(
+			promm.GaugeOpts{
+				Namespace: namespace, Name: "memory_bytes",
+				Help:        "Memory statistics from /proc/meminfo, by field (bytes).",
+				ConstLabels: labels,
+			},
+			[]string{"field"},
+		)
+		mc.pages = mc.metrics.NewGaugeVec(
+			promm.GaugeOpts{
+				Namespace: namespace, Name: "memory_hugepages",
+				Help:        "Huge page counts from /proc/meminfo, by field (pages).",
+				ConstLabels: labels,
+			},
+			[]string{"field"},
+		)
+	}
+
+	return mc, nil
+}
+
+func (mc *memCollector) Describe(ch chan<- *promm.Desc) {
+	mc.metrics.Describe(ch)
+}
+
+func (mc *memCollector) Collect(ch chan<- promm.Metric) {
+	if len(mc.fields) == 0 {
+		return
+	}
+	if err := mc.readStats(); err != nil {
+		log.Printf("Error reading memory stats: %v", err)
+	}
+	mc.metrics.Collect(ch)
+}
+
+func (mc *memCollector) readStats() error {
+	data, err := ioutil.ReadFile(mc.paths.proc(memInfoPath))
+	if err != nil {
+		return err
+	}
+	for _, l := range strings.Split(string(data), "\n") {
+		// Lines are of the form "MemTotal:       16314172 kB".
+		colon := strings.IndexByte(l, ':')
+		if colon < 0 {
+			continue
+		}
+		field := l[:colon]
+		if _, ok := mc.fields[field]; !ok {
+			continue
+		}
+		values := strings.Fields(l[colon+1:])
+		if len(values) == 0 {
+			continue
+		}
+		value, err := strconv.ParseUint(values[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(values) > 1 && values[1] == "kB" {
+			mc.bytes.With(promm.Labels{"field": field}).Set(float64(value * 1024))
+		} else {
+			mc.pages.With(promm.Labels{"field": field}).Set(float64(value))
+		}
+	}
+	return nil
+}
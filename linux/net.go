@@ -0,0 +1,243 @@
+package linux
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the sysfs root.
+	netPathSysClassNet = "class/net"
+	// Relative to an interface's directory in netPathSysClassNet.
+	netPathStatistics = "statistics"
+	netPathOperState  = "operstate"
+	netPathCarrier    = "carrier"
+	netPathSpeed      = "speed"
+	netPathMTU        = "mtu"
+)
+
+var (
+	// Counters in /sys/class/net/<interface>/statistics that can be exported.
+	netStatistics = []string{
+		"rx_bytes", "rx_packets", "rx_errors", "rx_dropped", "rx_fifo_errors",
+		"rx_frame_errors", "rx_compressed", "rx_crc_errors", "rx_length_errors",
+		"rx_missed_errors", "rx_over_errors", "rx_nohandler", "multicast",
+		"tx_bytes", "tx_packets", "tx_errors", "tx_dropped", "tx_fifo_errors",
+		"tx_compressed", "tx_aborted_errors", "tx_carrier_errors",
+		"tx_heartbeat_errors", "tx_window_errors", "collisions",
+	}
+	netStatisticHelp = map[string]string{
+		"rx_bytes": "Count of bytes received by network interface (bytes).",
+		"tx_bytes": "Count of bytes transmitted by network interface (bytes).",
+	}
+)
+
+type NetConfig struct {
+	// re2 pattern matched against interface names (e.g "eth0"). Only matching
+	// interfaces are exported. Defaults to matching all interfaces.
+	Include string
+	// re2 pattern matched against interface names. Matching interfaces are not
+	// exported. Defaults to excluding no interfaces.
+	Exclude string
+	// Statistics to export values for. See the (private) netStatistics
+	// variable for allowed values. Defaults to all of them.
+	Statistics []string
+}
+
+type netStatistic struct {
+	name string
+	vec  *util.ValueVec
+}
+
+type netCollector struct {
+	metrics    util.MetricCollection
+	paths      sysPaths
+	filter     nameFilter
+	statistics []netStatistic
+	operState  *promm.GaugeVec
+	carrier    *promm.GaugeVec
+	speed      *promm.GaugeVec
+	mtu        *promm.GaugeVec
+
+	// Guards operStates and interfaces across a read.
+	mu sync.Mutex
+	// Last seen operstate by interface, so that the previous state's metric
+	// can be removed when it changes.
+	operStates map[string]string
+	// Interfaces exported by the last read, so that the metrics of interfaces
+	// that have since gone (e.g container veths) can be deleted.
+	interfaces map[string]struct{}
+}
+
+func newNetCollector(cfg NetConfig, paths sysPaths, labels promm.Labels) (*netCollector, error) {
+	filter, err := newNameFilter(cfg.Include, cfg.Exclude, "")
+	if err != nil {
+		return nil, err
+	}
+	nc := &netCollector{
+		paths:      paths,
+		filter:     filter,
+		operStates: make(map[string]string),
+		interfaces: make(map[string]struct{}),
+	}
+
+	statistics := cfg.Statistics
+	if len(statistics) == 0 {
+		statistics = netStatistics
+	}
+	for _, stat := range statistics {
+		found := false
+		for _, knownStat := range netStatistics {
+			if knownStat == stat {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown network statistic %q, accepted values: %s",
+				stat, strings.Join(netStatistics, ", "))
+		}
+		help, ok := netStatisticHelp[stat]
+		if !ok {
+			help = fmt.Sprintf("Value of %s/%s by network interface (count).", netPathStatistics, stat)
+		}
+		nc.statistics = append(nc.statistics, netStatistic{
+			name: stat,
+			vec: nc.metrics.NewValueVec(
+				promm.Opts{
+					Namespace: namespace, Name: "net_" + stat,
+					Help:        help,
+					ConstLabels: labels,
+				},
+				promm.CounterValue,
+				[]string{"interface"},
+			),
+		})
+	}
+
+	nc.operState = nc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "net_operstate",
+			Help:        "Operational state of network interface, 1 for the current state.",
+			ConstLabels: labels,
+		},
+		[]string{"interface", "operstate"},
+	)
+	nc.carrier = nc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "net_carrier",
+			Help:        "1 if network interface has carrier, 0 otherwise.",
+			ConstLabels: labels,
+		},
+		[]string{"interface"},
+	)
+	nc.speed = nc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "net_speed_bytes",
+			Help:        "Link speed of network interface, where known (bytes per second).",
+			ConstLabels: labels,
+		},
+		[]string{"interface"},
+	)
+	nc.mtu = nc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "net_mtu_bytes",
+			Help:        "MTU of network interface (bytes).",
+			ConstLabels: labels,
+		},
+		[]string{"interface"},
+	)
+
+	return nc, nil
+}
+
+func (nc *netCollector) Describe(ch chan<- *promm.Desc) {
+	nc.metrics.Describe(ch)
+}
+
+func (nc *netCollector) Collect(ch chan<- promm.Metric) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if err := nc.readStats(); err != nil {
+		log.Print("Error getting network interfaces: ", err)
+	}
+	nc.metrics.Collect(ch)
+}
+
+func (nc *netCollector) readStats() error {
+	entries, err := ioutil.ReadDir(nc.paths.sys(netPathSysClassNet))
+	if err != nil {
+		return err
+	}
+	interfaces := make(map[string]struct{}, len(nc.interfaces))
+	for _, entry := range entries {
+		iface := entry.Name()
+		if !nc.filter.match(iface) {
+			continue
+		}
+		ifaceDir := nc.paths.sys(netPathSysClassNet, iface)
+		// Skip anything that isn't an interface, e.g "bonding_masters".
+		if _, err := os.Stat(filepath.Join(ifaceDir, netPathStatistics)); err != nil {
+			continue
+		}
+		interfaces[iface] = struct{}{}
+		labels := promm.Labels{"interface": iface}
+
+		for _, stat := range nc.statistics {
+			readIntFileIntoValueVec(stat.vec, labels,
+				filepath.Join(ifaceDir, netPathStatistics, stat.name))
+		}
+		readIntFileIntoGaugeVec(nc.mtu, labels, filepath.Join(ifaceDir, netPathMTU))
+
+		// carrier and speed cannot be read while the interface is down, and speed
+		// is -1 or unreadable for virtual interfaces.
+		if carrier, err := readIntFile(filepath.Join(ifaceDir, netPathCarrier)); err == nil {
+			nc.carrier.With(labels).Set(float64(carrier))
+		} else {
+			nc.carrier.Delete(labels)
+		}
+		if speed, err := readIntFile(filepath.Join(ifaceDir, netPathSpeed)); err == nil && speed > 0 {
+			// speed is in megabits per second.
+			nc.speed.With(labels).Set(float64(speed) * 1e6 / 8)
+		} else {
+			nc.speed.Delete(labels)
+		}
+
+		if data, err := ioutil.ReadFile(filepath.Join(ifaceDir, netPathOperState)); err == nil {
+			state := strings.TrimSpace(string(data))
+			if old, ok := nc.operStates[iface]; ok && old != state {
+				nc.operState.Delete(promm.Labels{"interface": iface, "operstate": old})
+			}
+			nc.operStates[iface] = state
+			nc.operState.With(promm.Labels{"interface": iface, "operstate": state}).Set(1)
+		}
+	}
+
+	// Interfaces come and go, e.g with containers.
+	for iface := range nc.interfaces {
+		if _, ok := interfaces[iface]; ok {
+			continue
+		}
+		labels := promm.Labels{"interface": iface}
+		for _, stat := range nc.statistics {
+			stat.vec.Delete(labels)
+		}
+		nc.mtu.Delete(labels)
+		nc.carrier.Delete(labels)
+		nc.speed.Delete(labels)
+		if state, ok := nc.operStates[iface]; ok {
+			nc.operState.Delete(promm.Labels{"interface": iface, "operstate": state})
+			delete(nc.operStates, iface)
+		}
+	}
+	nc.interfaces = interfaces
+	return nil
+}
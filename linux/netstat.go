@@ -0,0 +1,194 @@
+package linux
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the procfs root.
+	netSnmpPath      = "net/snmp"
+	netNetstatPath   = "net/netstat"
+	netSockstatPath  = "net/sockstat"
+	netSockstat6Path = "net/sockstat6"
+)
+
+var (
+	// Fields in /proc/net/snmp that are current values rather than counts of
+	// events since boot, named as <protocol>_<field>.
+	netstatGaugeFields = map[string]bool{
+		"Ip_Forwarding":    true,
+		"Ip_DefaultTTL":    true,
+		"Tcp_RtoAlgorithm": true,
+		"Tcp_RtoMin":       true,
+		"Tcp_RtoMax":       true,
+		"Tcp_MaxConn":      true,
+		"Tcp_CurrEstab":    true,
+	}
+)
+
+type NetStatConfig struct {
+	// Fields to export, named as <protocol>_<field>, e.g "Tcp_RetransSegs",
+	// "TcpExt_ListenOverflows" or "TCP_inuse", where the protocol is the line
+	// prefix in /proc/net/snmp, /proc/net/netstat or /proc/net/sockstat{,6}.
+	// The available fields depend on the kernel. Defaults to all fields.
+	Fields []string
+}
+
+type netStatCollector struct {
+	metrics util.MetricCollection
+	paths   sysPaths
+	// Fields to export, or nil for all.
+	fields map[string]bool
+	// Counts of events since boot, from /proc/net/snmp and /proc/net/netstat.
+	counts *util.ValueVec
+	// Current values from /proc/net/snmp.
+	values *promm.GaugeVec
+	// Socket usage from /proc/net/sockstat{,6}.
+	sockets *promm.GaugeVec
+}
+
+func newNetStatCollector(cfg NetStatConfig, paths sysPaths, labels promm.Labels) (*netStatCollector, error) {
+	nc := &netStatCollector{paths: paths}
+	if len(cfg.Fields) > 0 {
+		nc.fields = make(map[string]bool, len(cfg.Fields))
+		for _, field := range cfg.Fields {
+			if i := strings.IndexByte(field, '_'); i <= 0 || i == len(field)-1 {
+				return nil, fmt.Errorf("network protocol field %q is not of the form <protocol>_<field>", field)
+			}
+			nc.fields[field] = true
+		}
+	}
+	nc.counts = nc.metrics.NewValueVec(
+		promm.Opts{
+			Namespace: namespace, Name: "netstat_count",
+			Help:        "Network protocol event counts from /proc/net/snmp and /proc/net/netstat (count).",
+			ConstLabels: labels,
+		},
+		promm.CounterValue,
+		[]string{"protocol", "field"},
+	)
+	nc.values = nc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "netstat_value",
+			Help:        "Network protocol current values from /proc/net/snmp.",
+			ConstLabels: labels,
+		},
+		[]string{"protocol", "field"},
+	)
+	nc.sockets = nc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "sockstat",
+			Help:        "Socket usage from /proc/net/sockstat and /proc/net/sockstat6 (sockets, or pages for \"mem\" and bytes for \"memory\").",
+			ConstLabels: labels,
+		},
+		[]string{"protocol", "field"},
+	)
+	return nc, nil
+}
+
+func (nc *netStatCollector) Describe(ch chan<- *promm.Desc) {
+	nc.metrics.Describe(ch)
+}
+
+func (nc *netStatCollector) Collect(ch chan<- promm.Metric) {
+	for _, path := range []string{netSnmpPath, netNetstatPath} {
+		if err := nc.readTable(nc.paths.proc(path)); err != nil {
+			log.Printf("Error reading network protocol statistics: %v", err)
+		}
+	}
+	for _, path := range []string{netSockstatPath, netSockstat6Path} {
+		if err := nc.readSockstat(nc.paths.proc(path)); err != nil {
+			// sockstat6 is missing if IPv6 is disabled.
+			if os.IsNotExist(err) && path == netSockstat6Path {
+				continue
+			}
+			log.Printf("Error reading socket statistics: %v", err)
+		}
+	}
+	nc.metrics.Collect(ch)
+}
+
+func (nc *netStatCollector) wanted(protocol, field string) bool {
+	return nc.fields == nil || nc.fields[protocol+"_"+field]
+}
+
+// Reads a file of the format of /proc/net/snmp and /proc/net/netstat, in which
+// each protocol has a pair of lines: one of field names and one of values.
+func (nc *netStatCollector) readTable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines are of the form:
+		// Tcp: RtoAlgorithm RtoMin ...
+		// Tcp: 1 200 ...
+		names := strings.Fields(scanner.Text())
+		if !scanner.Scan() {
+			break
+		}
+		values := strings.Fields(scanner.Text())
+		if len(names) == 0 || len(names) != len(values) || names[0] != values[0] {
+			return fmt.Errorf("mismatched lines for %q in %q", names, path)
+		}
+		protocol := strings.TrimSuffix(names[0], ":")
+		for i := 1; i < len(names); i++ {
+			field := names[i]
+			if !nc.wanted(protocol, field) {
+				continue
+			}
+			value, err := strconv.ParseFloat(values[i], 64)
+			if err != nil {
+				continue
+			}
+			labels := promm.Labels{"protocol": protocol, "field": field}
+			if netstatGaugeFields[protocol+"_"+field] {
+				nc.values.With(labels).Set(value)
+			} else {
+				nc.counts.Set(labels, value)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// Reads a file of the format of /proc/net/sockstat, in which each line is a
+// protocol followed by field name and value pairs.
+func (nc *netStatCollector) readSockstat(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines are of the form "TCP: inuse 4 orphan 0 tw 0 alloc 4 mem 0".
+		values := strings.Fields(scanner.Text())
+		if len(values) == 0 {
+			continue
+		}
+		protocol := strings.TrimSuffix(values[0], ":")
+		for i := 1; i+1 < len(values); i += 2 {
+			field := values[i]
+			if !nc.wanted(protocol, field) {
+				continue
+			}
+			value, err := strconv.ParseFloat(values[i+1], 64)
+			if err != nil {
+				continue
+			}
+			nc.sockets.With(promm.Labels{"protocol": protocol, "field": field}).Set(value)
+		}
+	}
+	return scanner.Err()
+}
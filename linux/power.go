@@ -0,0 +1,118 @@
+package linux
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sync"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the sysfs root.
+	powerSupplyPath = "class/power_supply"
+	// Relative to a supply's directory in powerSupplyPath.
+	powerSupplyTypePath   = "type"
+	powerSupplyStatusPath = "status"
+)
+
+var (
+	// Numeric attributes of power supplies, and the options for the metrics
+	// exported from them. scale converts the attribute's value to the metric's
+	// unit. Supplies have only the attributes that their driver reports.
+	powerSupplyAttributes = []struct {
+		file  string
+		opts  promm.GaugeOpts
+		scale float64
+	}{
+		{"online", promm.GaugeOpts{Name: "power_supply_online", Help: "1 if power supply is connected, 0 otherwise."}, 1},
+		{"capacity", promm.GaugeOpts{Name: "power_supply_capacity_ratio", Help: "Charge of battery (ratio)."}, 0.01},
+		{"voltage_now", promm.GaugeOpts{Name: "power_supply_voltage_volts", Help: "Voltage of power supply (volts)."}, 1e-6},
+		{"current_now", promm.GaugeOpts{Name: "power_supply_current_amps", Help: "Current of power supply (amps)."}, 1e-6},
+		{"power_now", promm.GaugeOpts{Name: "power_supply_power_watts", Help: "Power of power supply (watts)."}, 1e-6},
+		// Energy is in microwatt hours.
+		{"energy_now", promm.GaugeOpts{Name: "power_supply_energy_joules", Help: "Energy stored in battery (joules)."}, 3.6e-3},
+		{"energy_full", promm.GaugeOpts{Name: "power_supply_energy_full_joules", Help: "Energy stored in battery when full (joules)."}, 3.6e-3},
+		{"energy_full_design", promm.GaugeOpts{Name: "power_supply_energy_full_design_joules", Help: "Energy stored in battery when full, as designed (joules)."}, 3.6e-3},
+	}
+)
+
+type powerSupplyCollector struct {
+	metrics util.MetricCollection
+	paths   sysPaths
+	// Metrics corresponding to powerSupplyAttributes.
+	attributes []*promm.GaugeVec
+	status     *promm.GaugeVec
+
+	// readStats empties the supply metrics before refilling them.
+	mu sync.Mutex
+}
+
+func newPowerSupplyCollector(paths sysPaths, labels promm.Labels) *powerSupplyCollector {
+	pc := &powerSupplyCollector{
+		paths:      paths,
+		attributes: make([]*promm.GaugeVec, 0, len(powerSupplyAttributes)),
+	}
+	for _, attr := range powerSupplyAttributes {
+		opts := attr.opts
+		opts.Namespace = namespace
+		opts.ConstLabels = labels
+		pc.attributes = append(pc.attributes, pc.metrics.NewGaugeVec(opts, []string{"supply", "type"}))
+	}
+	pc.status = pc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "power_supply_status",
+			Help:        "Charging status of battery (e.g Charging, Discharging, Full), 1 for the current status.",
+			ConstLabels: labels,
+		},
+		[]string{"supply", "type", "status"},
+	)
+	return pc
+}
+
+func (pc *powerSupplyCollector) Describe(ch chan<- *promm.Desc) {
+	pc.metrics.Describe(ch)
+}
+
+func (pc *powerSupplyCollector) Collect(ch chan<- promm.Metric) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if err := pc.readStats(); err != nil {
+		log.Printf("Error reading power supplies: %v", err)
+	}
+	pc.metrics.Collect(ch)
+}
+
+func (pc *powerSupplyCollector) readStats() error {
+	entries, err := ioutil.ReadDir(pc.paths.sys(powerSupplyPath))
+	if err != nil {
+		return err
+	}
+	// Supplies (e.g USB UPSes) come and go, and change status.
+	for _, vec := range pc.attributes {
+		vec.Reset()
+	}
+	pc.status.Reset()
+
+	for _, entry := range entries {
+		supply := entry.Name()
+		supplyDir := pc.paths.sys(powerSupplyPath, supply)
+		supplyType, err := readStringFile(filepath.Join(supplyDir, powerSupplyTypePath))
+		if err != nil {
+			log.Printf("Unable to read power supply type in %q: %v", supplyDir, err)
+			continue
+		}
+		labels := promm.Labels{"supply": supply, "type": supplyType}
+		for i, attr := range powerSupplyAttributes {
+			if value, err := readIntFile(filepath.Join(supplyDir, attr.file)); err == nil {
+				pc.attributes[i].With(labels).Set(float64(value) * attr.scale)
+			}
+		}
+		if status, err := readStringFile(filepath.Join(supplyDir, powerSupplyStatusPath)); err == nil {
+			pc.status.With(promm.Labels{"supply": supply, "type": supplyType, "status": status}).Set(1)
+		}
+	}
+	return nil
+}
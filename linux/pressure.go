@@ -0,0 +1,102 @@
+package linux
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the procfs root.
+	pressurePath = "pressure"
+)
+
+var (
+	// Resources with pressure stall information, named as their files in
+	// pressurePath.
+	pressureResources = []string{"cpu", "memory", "io"}
+)
+
+type pressureCollector struct {
+	metrics util.MetricCollection
+	paths   sysPaths
+	avg     *promm.GaugeVec
+	total   *util.ValueVec
+}
+
+func newPressureCollector(paths sysPaths, labels promm.Labels) *pressureCollector {
+	pc := &pressureCollector{paths: paths}
+	pc.avg = pc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "pressure_stalled_ratio",
+			Help:        "Share of time that some or all tasks were stalled on a resource, averaged over a window (ratio).",
+			ConstLabels: labels,
+		},
+		[]string{"resource", "kind", "window"},
+	)
+	pc.total = pc.metrics.NewValueVec(
+		promm.Opts{
+			Namespace: namespace, Name: "pressure_stalled_seconds",
+			Help:        "Time that some or all tasks were stalled on a resource (seconds).",
+			ConstLabels: labels,
+		},
+		promm.CounterValue,
+		[]string{"resource", "kind"},
+	)
+	return pc
+}
+
+func (pc *pressureCollector) Describe(ch chan<- *promm.Desc) {
+	pc.metrics.Describe(ch)
+}
+
+func (pc *pressureCollector) Collect(ch chan<- promm.Metric) {
+	for _, resource := range pressureResources {
+		if err := pc.readStats(resource); err != nil {
+			log.Printf("Error reading %s pressure: %v", resource, err)
+		}
+	}
+	pc.metrics.Collect(ch)
+}
+
+func (pc *pressureCollector) readStats(resource string) error {
+	f, err := os.Open(pc.paths.proc(pressurePath, resource))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines are of the form:
+		// some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+		values := strings.Fields(scanner.Text())
+		if len(values) == 0 {
+			continue
+		}
+		kind := values[0]
+		for _, kv := range values[1:] {
+			eq := strings.IndexByte(kv, '=')
+			if eq < 0 {
+				continue
+			}
+			key := kv[:eq]
+			value, err := strconv.ParseFloat(kv[eq+1:], 64)
+			if err != nil {
+				continue
+			}
+			if key == "total" {
+				// total is in microseconds.
+				pc.total.Set(promm.Labels{"resource": resource, "kind": kind}, value/1e6)
+			} else if strings.HasPrefix(key, "avg") {
+				// Averages are percentages, over a window of the given seconds.
+				pc.avg.With(promm.Labels{"resource": resource, "kind": kind, "window": strings.TrimPrefix(key, "avg") + "s"}).Set(value / 100)
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,400 @@
+package linux
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to a process's directory in the procfs root.
+	procStatPath    = "stat"
+	procStatusPath  = "status"
+	procIoPath      = "io"
+	procFdPath      = "fd"
+	procCmdlinePath = "cmdline"
+	procExePath     = "exe"
+	// Appended by the kernel to the exe link of a process whose executable has
+	// been deleted (e.g by a package upgrade).
+	procExeDeletedSuffix = " (deleted)"
+)
+
+var (
+	errMalformedProcStat = errors.New("malformed stat")
+)
+
+type ProcessGroupConfig struct {
+	// Name of the group, used as the "group" label value.
+	Name string
+	// Processes match the group if their command name (as in
+	// /proc/[pid]/comm, truncated to 15 characters by the kernel) is any of
+	// these, ...
+	Comm []string
+	// ... or if their command line, with arguments separated by spaces,
+	// matches this re2 pattern, ...
+	Cmdline string
+	// ... or if their executable is any of these paths.
+	Exe []string
+}
+
+// A process, identified by its PID and start time (as PIDs are reused).
+type procKey struct {
+	pid       int
+	startTime uint64
+}
+
+// Counter values last read for a process, so that increments can be added
+// to its group's totals.
+type procCounters struct {
+	userSeconds   float64
+	systemSeconds float64
+	readBytes     float64
+	writtenBytes  float64
+}
+
+type processGroup struct {
+	name    string
+	comm    map[string]struct{}
+	cmdline *regexp.Regexp
+	exe     map[string]struct{}
+	// Counter totals for the group, including processes that have exited.
+	totals procCounters
+}
+
+func (pg *processGroup) match(comm string, cmdline func() string, exe func() string) bool {
+	if _, ok := pg.comm[comm]; ok {
+		return true
+	}
+	if pg.cmdline != nil && pg.cmdline.MatchString(cmdline()) {
+		return true
+	}
+	if len(pg.exe) > 0 {
+		if _, ok := pg.exe[exe()]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+type processCollector struct {
+	metrics       util.MetricCollection
+	paths         sysPaths
+	jiffiesScaler float64
+	groups        []*processGroup
+
+	// Stops concurrent reads from each adding the same deltas to the counters.
+	mu sync.Mutex
+	// Processes seen in the previous Collect, and their counter values then.
+	procs map[procKey]procCounters
+
+	procCount     *promm.GaugeVec
+	threads       *promm.GaugeVec
+	residentBytes *promm.GaugeVec
+	virtualBytes  *promm.GaugeVec
+	openFds       *promm.GaugeVec
+	cpuSeconds    *util.ValueVec
+	readBytes     *util.ValueVec
+	writtenBytes  *util.ValueVec
+}
+
+func newProcessCollector(cfgs []ProcessGroupConfig, jiffiesScaler float64, paths sysPaths, labels promm.Labels) (*processCollector, error) {
+	pc := &processCollector{
+		paths:         paths,
+		jiffiesScaler: jiffiesScaler,
+		procs:         make(map[procKey]procCounters),
+	}
+	names := map[string]bool{}
+	for _, cfg := range cfgs {
+		if cfg.Name == "" {
+			return nil, errors.New("process group has no name")
+		}
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("duplicate process group name %q", cfg.Name)
+		}
+		names[cfg.Name] = true
+		if len(cfg.Comm) == 0 && cfg.Cmdline == "" && len(cfg.Exe) == 0 {
+			return nil, fmt.Errorf("process group %q has no comm, cmdline or exe to match", cfg.Name)
+		}
+		pg := &processGroup{
+			name: cfg.Name,
+			comm: make(map[string]struct{}, len(cfg.Comm)),
+			exe:  make(map[string]struct{}, len(cfg.Exe)),
+		}
+		for _, comm := range cfg.Comm {
+			pg.comm[comm] = struct{}{}
+		}
+		for _, exe := range cfg.Exe {
+			pg.exe[exe] = struct{}{}
+		}
+		if cfg.Cmdline != "" {
+			var err error
+			if pg.cmdline, err = regexp.Compile(cfg.Cmdline); err != nil {
+				return nil, fmt.Errorf("process group %q: %v", cfg.Name, err)
+			}
+		}
+		pc.groups = append(pc.groups, pg)
+	}
+
+	pc.procCount = pc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "process_group_procs",
+			Help:        "Processes in process group (count).",
+			ConstLabels: labels,
+		},
+		[]string{"group"},
+	)
+	pc.threads = pc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "process_group_threads",
+			Help:        "Threads in process group (count).",
+			ConstLabels: labels,
+		},
+		[]string{"group"},
+	)
+	pc.residentBytes = pc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "process_group_resident_memory_bytes",
+			Help:        "Resident memory of processes in process group (bytes).",
+			ConstLabels: labels,
+		},
+		[]string{"group"},
+	)
+	pc.virtualBytes = pc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "process_group_virtual_memory_bytes",
+			Help:        "Virtual memory of processes in process group (bytes).",
+			ConstLabels: labels,
+		},
+		[]string{"group"},
+	)
+	pc.openFds = pc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "process_group_open_fds",
+			Help:        "Open file descriptors of processes in process group (count).",
+			ConstLabels: labels,
+		},
+		[]string{"group"},
+	)
+	pc.cpuSeconds = pc.metrics.NewValueVec(
+		promm.Opts{
+			Namespace: namespace, Name: "process_group_cpu_seconds",
+			Help:        "CPU time used by processes in process group, by mode (seconds).",
+			ConstLabels: labels,
+		},
+		promm.CounterValue,
+		[]string{"group", "mode"},
+	)
+	pc.readBytes = pc.metrics.NewValueVec(
+		promm.Opts{
+			Namespace: namespace, Name: "process_group_read_bytes",
+			Help:        "Data read from storage by processes in process group (bytes).",
+			ConstLabels: labels,
+		},
+		promm.CounterValue,
+		[]string{"group"},
+	)
+	pc.writtenBytes = pc.metrics.NewValueVec(
+		promm.Opts{
+			Namespace: namespace, Name: "process_group_written_bytes",
+			Help:        "Data written to storage by processes in process group (bytes).",
+			ConstLabels: labels,
+		},
+		promm.CounterValue,
+		[]string{"group"},
+	)
+	return pc, nil
+}
+
+func (pc *processCollector) Describe(ch chan<- *promm.Desc) {
+	pc.metrics.Describe(ch)
+}
+
+func (pc *processCollector) Collect(ch chan<- promm.Metric) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if err := pc.readStats(); err != nil {
+		log.Printf("Error reading processes: %v", err)
+	}
+	pc.metrics.Collect(ch)
+}
+
+// Gauge values summed over the processes in a group.
+type processGroupGauges struct {
+	procs, threads, residentBytes, virtualBytes, openFds float64
+}
+
+func (pc *processCollector) readStats() error {
+	entries, err := ioutil.ReadDir(pc.paths.proc())
+	if err != nil {
+		return err
+	}
+	gauges := make([]processGroupGauges, len(pc.groups))
+	seen := make(map[procKey]procCounters, len(pc.procs))
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// Processes can exit at any point while being read, so errors reading
+		// them are ignored.
+		pc.readProcess(pid, gauges, seen)
+	}
+	pc.procs = seen
+
+	for i, pg := range pc.groups {
+		labels := promm.Labels{"group": pg.name}
+		pc.procCount.With(labels).Set(gauges[i].procs)
+		pc.threads.With(labels).Set(gauges[i].threads)
+		pc.residentBytes.With(labels).Set(gauges[i].residentBytes)
+		pc.virtualBytes.With(labels).Set(gauges[i].virtualBytes)
+		pc.openFds.With(labels).Set(gauges[i].openFds)
+		pc.cpuSeconds.Set(promm.Labels{"group": pg.name, "mode": "user"}, pg.totals.userSeconds)
+		pc.cpuSeconds.Set(promm.Labels{"group": pg.name, "mode": "system"}, pg.totals.systemSeconds)
+		pc.readBytes.Set(labels, pg.totals.readBytes)
+		pc.writtenBytes.Set(labels, pg.totals.writtenBytes)
+	}
+	return nil
+}
+
+// Reads a process, adding it to the first group that it matches, if any.
+func (pc *processCollector) readProcess(pid int, gauges []processGroupGauges, seen map[procKey]procCounters) {
+	procDir := pc.paths.proc(strconv.Itoa(pid))
+	stat, err := ioutil.ReadFile(filepath.Join(procDir, procStatPath))
+	if err != nil {
+		return
+	}
+	comm, statValues, err := parseProcStat(stat)
+	if err != nil {
+		log.Printf("Unable to parse %q: %v", filepath.Join(procDir, procStatPath), err)
+		return
+	}
+
+	cmdline := func() string {
+		data, _ := ioutil.ReadFile(filepath.Join(procDir, procCmdlinePath))
+		return string(bytes.TrimRight(bytes.Replace(data, []byte{0}, []byte{' '}, -1), " "))
+	}
+	exe := func() string {
+		target, _ := os.Readlink(filepath.Join(procDir, procExePath))
+		return strings.TrimSuffix(target, procExeDeletedSuffix)
+	}
+	groupIndex := -1
+	for i, pg := range pc.groups {
+		if pg.match(comm, cmdline, exe) {
+			groupIndex = i
+			break
+		}
+	}
+	if groupIndex < 0 {
+		return
+	}
+	pg := pc.groups[groupIndex]
+	g := &gauges[groupIndex]
+
+	// statValues starts at the third field of stat (state), so utime (the
+	// 14th field) is at index 11.
+	utime, _ := strconv.ParseUint(statValues[11], 10, 64)
+	stime, _ := strconv.ParseUint(statValues[12], 10, 64)
+	startTime, _ := strconv.ParseUint(statValues[19], 10, 64)
+	key := procKey{pid: pid, startTime: startTime}
+	counters := procCounters{
+		userSeconds:   float64(utime) * pc.jiffiesScaler,
+		systemSeconds: float64(stime) * pc.jiffiesScaler,
+	}
+
+	g.procs++
+	if status, err := readProcStatus(filepath.Join(procDir, procStatusPath)); err == nil {
+		g.threads += status["Threads"]
+		// Memory values are in kB.
+		g.residentBytes += status["VmRSS"] * 1024
+		g.virtualBytes += status["VmSize"] * 1024
+	}
+	if fds, err := ioutil.ReadDir(filepath.Join(procDir, procFdPath)); err == nil {
+		g.openFds += float64(len(fds))
+	}
+	// io is only readable by the process's owner.
+	readKeyedFile(filepath.Join(procDir, procIoPath), func(values []string) {
+		// Lines are of the form "read_bytes: 1234".
+		if len(values) < 2 {
+			return
+		}
+		value, err := strconv.ParseUint(values[1], 10, 64)
+		if err != nil {
+			return
+		}
+		switch values[0] {
+		case "read_bytes:":
+			counters.readBytes = float64(value)
+		case "write_bytes:":
+			counters.writtenBytes = float64(value)
+		}
+	})
+
+	// Add the increments since the previous Collect to the group's totals. A
+	// process that is new to the group contributes all of its counts so far.
+	prev := pc.procs[key]
+	pg.totals.userSeconds += nonNegative(counters.userSeconds - prev.userSeconds)
+	pg.totals.systemSeconds += nonNegative(counters.systemSeconds - prev.systemSeconds)
+	pg.totals.readBytes += nonNegative(counters.readBytes - prev.readBytes)
+	pg.totals.writtenBytes += nonNegative(counters.writtenBytes - prev.writtenBytes)
+	seen[key] = counters
+}
+
+// Splits the contents of /proc/[pid]/stat into the command name, and the
+// fields that follow it.
+func parseProcStat(stat []byte) (string, []string, error) {
+	// The command name is in parentheses, and may itself contain spaces and
+	// parentheses, so the last ")" ends it.
+	start := bytes.IndexByte(stat, '(')
+	end := bytes.LastIndexByte(stat, ')')
+	if start < 0 || end < start {
+		return "", nil, errMalformedProcStat
+	}
+	values := strings.Fields(string(stat[end+1:]))
+	// starttime is the last field used.
+	if len(values) < 20 {
+		return "", nil, errMalformedProcStat
+	}
+	return string(stat[start+1 : end]), values, nil
+}
+
+// Reads the numeric fields of /proc/[pid]/status, ignoring any units.
+func readProcStatus(path string) (map[string]float64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	status := map[string]float64{}
+	for _, l := range strings.Split(string(data), "\n") {
+		// Lines are of the form "VmRSS:	    1234 kB".
+		colon := strings.IndexByte(l, ':')
+		if colon < 0 {
+			continue
+		}
+		values := strings.Fields(l[colon+1:])
+		if len(values) == 0 {
+			continue
+		}
+		if value, err := strconv.ParseUint(values[0], 10, 64); err == nil {
+			status[l[:colon]] = float64(value)
+		}
+	}
+	return status, nil
+}
+
+func nonNegative(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	return v
+}
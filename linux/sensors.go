@@ -0,0 +1,279 @@
+package linux
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the sysfs root.
+	w1DevicesPath  = "bus/w1/devices"
+	iioDevicesPath = "bus/iio/devices"
+	// Relative to a 1-Wire device's directory. temperature is only present on
+	// newer kernels, and is preferred when present.
+	w1TemperaturePath = "temperature"
+	w1SlavePath       = "w1_slave"
+	// Prefix of 1-Wire bus master entries in w1DevicesPath, which are not
+	// sensors.
+	w1BusMasterPrefix = "w1_bus_master"
+	// Temperature (in millidegrees Celsius) reported by DS18B20 sensors that
+	// have reset without performing a conversion.
+	w1PowerOnResetTemp = 85000
+)
+
+var (
+	errW1Crc           = errors.New("CRC check failed")
+	errW1PowerOnReset  = errors.New("power-on reset value read")
+	errW1MalformedData = errors.New("malformed w1_slave data")
+
+	// IIO channels exported, and the scale converting their processed values
+	// (as given by the IIO sysfs ABI) to the metrics' units.
+	iioChannels = []struct {
+		channel string
+		scale   float64
+	}{
+		// millidegrees Celsius.
+		{"temp", 0.001},
+		// milli-percent.
+		{"humidityrelative", 0.00001},
+		// kilopascals.
+		{"pressure", 1000},
+	}
+)
+
+type SensorsConfig struct {
+	// Friendly names for sensors, keyed by 1-Wire device ID (e.g
+	// "28-0316a2795aff"), or by IIO device (e.g "iio:device0") or IIO device
+	// name (e.g "bme280"). Sensors without a name are labelled with their ID.
+	Sensor map[string]SensorConfig
+}
+
+type SensorConfig struct {
+	Name string
+}
+
+type sensorsCollector struct {
+	metrics    util.MetricCollection
+	paths      sysPaths
+	sensorCfgs map[string]SensorConfig
+	// Metrics by IIO channel, also used for 1-Wire temperatures.
+	channels   map[string]*promm.GaugeVec
+	readErrors *promm.CounterVec
+
+	// Collect resets the channel metrics before reading the sensors again.
+	mu sync.Mutex
+}
+
+func newSensorsCollector(cfg SensorsConfig, paths sysPaths, labels promm.Labels) *sensorsCollector {
+	sc := &sensorsCollector{
+		paths:      paths,
+		sensorCfgs: cfg.Sensor,
+		channels:   make(map[string]*promm.GaugeVec, len(iioChannels)),
+	}
+	labelNames := []string{"device", "name"}
+	sc.channels["temp"] = sc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "sensor_temp_celsius",
+			Help:        "Temperature by 1-Wire or IIO sensor (degrees Celsius).",
+			ConstLabels: labels,
+		},
+		labelNames,
+	)
+	sc.channels["humidityrelative"] = sc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "sensor_humidity_ratio",
+			Help:        "Relative humidity by IIO sensor (ratio).",
+			ConstLabels: labels,
+		},
+		labelNames,
+	)
+	sc.channels["pressure"] = sc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "sensor_pressure_pascals",
+			Help:        "Air pressure by IIO sensor (pascals).",
+			ConstLabels: labels,
+		},
+		labelNames,
+	)
+	sc.readErrors = sc.metrics.NewCounterVec(
+		promm.CounterOpts{
+			Namespace: namespace, Name: "sensor_read_errors",
+			Help:        "Failed reads of 1-Wire or IIO sensor, including CRC failures (count).",
+			ConstLabels: labels,
+		},
+		labelNames,
+	)
+	return sc
+}
+
+func (sc *sensorsCollector) Describe(ch chan<- *promm.Desc) {
+	sc.metrics.Describe(ch)
+}
+
+func (sc *sensorsCollector) Collect(ch chan<- promm.Metric) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	// Sensors come and go, and a failed read should not leave a stale value.
+	for _, vec := range sc.channels {
+		vec.Reset()
+	}
+	// Either bus may be absent, if its drivers are not loaded.
+	if err := sc.readW1(); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error reading 1-Wire sensors: %v", err)
+	}
+	if err := sc.readIio(); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error reading IIO sensors: %v", err)
+	}
+	sc.metrics.Collect(ch)
+}
+
+// Returns the labels for a sensor, giving its friendly name from the first of
+// ids that has one.
+func (sc *sensorsCollector) sensorLabels(device string, ids ...string) promm.Labels {
+	name := device
+	for _, id := range ids {
+		if cfg, ok := sc.sensorCfgs[id]; ok && cfg.Name != "" {
+			name = cfg.Name
+			break
+		}
+	}
+	return promm.Labels{"device": device, "name": name}
+}
+
+func (sc *sensorsCollector) readW1() error {
+	entries, err := ioutil.ReadDir(sc.paths.sys(w1DevicesPath))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		device := entry.Name()
+		if strings.HasPrefix(device, w1BusMasterPrefix) {
+			continue
+		}
+		deviceDir := sc.paths.sys(w1DevicesPath, device)
+		temp, err := readW1Temperature(deviceDir)
+		if os.IsNotExist(err) {
+			// Not a temperature sensor.
+			continue
+		}
+		labels := sc.sensorLabels(device, device)
+		if err != nil {
+			log.Printf("Error reading 1-Wire sensor %s: %v", device, err)
+			sc.readErrors.With(labels).Inc()
+			continue
+		}
+		sc.channels["temp"].With(labels).Set(float64(temp) / 1000)
+	}
+	return nil
+}
+
+// Reads the temperature of a 1-Wire sensor, in millidegrees Celsius.
+func readW1Temperature(deviceDir string) (int64, error) {
+	var temp int64
+	if _, err := os.Stat(filepath.Join(deviceDir, w1TemperaturePath)); err == nil {
+		// The driver checks the CRC, and fails the read if it does not match.
+		if temp, err = readIntFile(filepath.Join(deviceDir, w1TemperaturePath)); err != nil {
+			return 0, err
+		}
+	} else {
+		data, err := ioutil.ReadFile(filepath.Join(deviceDir, w1SlavePath))
+		if err != nil {
+			return 0, err
+		}
+		if temp, err = parseW1Slave(string(data)); err != nil {
+			return 0, err
+		}
+	}
+	if temp == w1PowerOnResetTemp {
+		return 0, errW1PowerOnReset
+	}
+	return temp, nil
+}
+
+// Parses the contents of a w1_slave file, which are of the form:
+// 72 01 4b 46 7f ff 0e 10 57 : crc=57 YES
+// 72 01 4b 46 7f ff 0e 10 57 t=23125
+func parseW1Slave(data string) (int64, error) {
+	lines := strings.Split(strings.TrimSpace(data), "\n")
+	if len(lines) < 2 {
+		return 0, errW1MalformedData
+	}
+	if !strings.HasSuffix(strings.TrimSpace(lines[0]), "YES") {
+		return 0, errW1Crc
+	}
+	eq := strings.LastIndex(lines[1], "t=")
+	if eq < 0 {
+		return 0, errW1MalformedData
+	}
+	return strconv.ParseInt(strings.TrimSpace(lines[1][eq+2:]), 10, 64)
+}
+
+func (sc *sensorsCollector) readIio() error {
+	entries, err := ioutil.ReadDir(sc.paths.sys(iioDevicesPath))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		device := entry.Name()
+		deviceDir := sc.paths.sys(iioDevicesPath, device)
+		// Not all IIO devices have a name, but friendly names are usually
+		// given by it, as device numbers can change between boots.
+		chipName, _ := readStringFile(filepath.Join(deviceDir, "name"))
+		labels := sc.sensorLabels(device, device, chipName)
+		for _, ch := range iioChannels {
+			value, err := readIioChannel(deviceDir, ch.channel)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				log.Printf("Error reading IIO sensor %s channel %s: %v", device, ch.channel, err)
+				sc.readErrors.With(labels).Inc()
+				continue
+			}
+			sc.channels[ch.channel].With(labels).Set(value * ch.scale)
+		}
+	}
+	return nil
+}
+
+// Reads the processed value of an IIO channel, from in_<channel>_input if
+// present, otherwise computing it from in_<channel>_raw, _offset and _scale.
+func readIioChannel(deviceDir, channel string) (float64, error) {
+	prefix := filepath.Join(deviceDir, "in_"+channel)
+	if value, err := readFloatFile(prefix + "_input"); !os.IsNotExist(err) {
+		return value, err
+	}
+	raw, err := readFloatFile(prefix + "_raw")
+	if err != nil {
+		return 0, err
+	}
+	offset, err := readFloatFile(prefix + "_offset")
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	scale, err := readFloatFile(prefix + "_scale")
+	if os.IsNotExist(err) {
+		scale = 1
+	} else if err != nil {
+		return 0, err
+	}
+	return (raw + offset) * scale, nil
+}
+
+// Read a short text file containing a single decimal number.
+func readFloatFile(path string) (float64, error) {
+	s, err := readStringFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(s, 64)
+}
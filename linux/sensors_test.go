@@ -0,0 +1,140 @@
+package linux
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Fixture IIO device directories, by their name in sysfs. The names contain
+// ':', which Go module zips do not allow, so the fixtures are linked into a
+// temporary sysfs tree by testSensorsPaths.
+var testIioDevices = map[string]string{
+	"iio:device0": "testdata/iio/device0",
+	"iio:device1": "testdata/iio/device1",
+}
+
+// Returns paths with a sysfs root containing the fixture 1-Wire and IIO
+// devices. As in sysfs, the devices are symlinks.
+func testSensorsPaths(t *testing.T) sysPaths {
+	t.Helper()
+	paths := sysPaths{procRoot: testProcRoot, sysRoot: t.TempDir()}
+	link := func(target, name string) {
+		target, err := filepath.Abs(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link(testPaths.sys(w1DevicesPath), paths.sys(w1DevicesPath))
+	for device, dir := range testIioDevices {
+		link(dir, paths.sys(iioDevicesPath, device))
+	}
+	return paths
+}
+
+func TestParseW1Slave(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		data    string
+		want    int64
+		wantErr error
+	}{
+		{"ok", "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n", 23125, nil},
+		{"negative", "5e ff 4b 46 7f ff 02 10 2c : crc=2c YES\n5e ff 4b 46 7f ff 02 10 2c t=-10125\n", -10125, nil},
+		{"crc NO", "72 01 4b 46 7f ff 0e 10 57 : crc=ff NO\n72 01 4b 46 7f ff 0e 10 57 t=23125\n", 0, errW1Crc},
+		// The power-on reset value parses, and is rejected by
+		// readW1Temperature.
+		{"power-on reset", "50 05 4b 46 7f ff 0c 10 1c : crc=1c YES\n50 05 4b 46 7f ff 0c 10 1c t=85000\n", 85000, nil},
+		{"truncated", "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n", 0, errW1MalformedData},
+		{"no temperature", "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57\n", 0, errW1MalformedData},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseW1Slave(tc.data)
+			if err != tc.wantErr {
+				t.Fatalf("parseW1Slave error = %v, want %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("parseW1Slave = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadW1Temperature(t *testing.T) {
+	for _, tc := range []struct {
+		device  string
+		want    int64
+		wantErr error
+	}{
+		{"28-000000000001", 23125, nil},
+		{"28-000000000002", 0, errW1Crc},
+		{"28-000000000003", 0, errW1PowerOnReset},
+		// temperature is preferred over w1_slave, which here has a bad CRC.
+		{"28-000000000004", 19500, nil},
+	} {
+		t.Run(tc.device, func(t *testing.T) {
+			got, err := readW1Temperature(testPaths.sys(w1DevicesPath, tc.device))
+			if err != tc.wantErr {
+				t.Fatalf("readW1Temperature error = %v, want %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("readW1Temperature = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadIioChannel(t *testing.T) {
+	paths := testSensorsPaths(t)
+	for _, tc := range []struct {
+		device, channel string
+		want            float64
+	}{
+		// From in_<channel>_input.
+		{"iio:device0", "temp", 21370},
+		{"iio:device0", "pressure", 101.325},
+		// From (in_<channel>_raw + in_<channel>_offset) * in_<channel>_scale.
+		{"iio:device1", "temp", 20000},
+		// Without an offset.
+		{"iio:device1", "humidityrelative", 45000},
+	} {
+		t.Run(tc.device+"/"+tc.channel, func(t *testing.T) {
+			got, err := readIioChannel(paths.sys(iioDevicesPath, tc.device), tc.channel)
+			if err != nil {
+				t.Fatalf("readIioChannel: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("readIioChannel = %v, want %v", got, tc.want)
+			}
+		})
+	}
+	// Channels that the device does not have are reported as not existing, so
+	// that they can be skipped.
+	if _, err := readIioChannel(paths.sys(iioDevicesPath, "iio:device1"), "pressure"); !os.IsNotExist(err) {
+		t.Errorf("readIioChannel error = %v for a missing channel, want not exist", err)
+	}
+}
+
+func TestSensors(t *testing.T) {
+	sc := newSensorsCollector(SensorsConfig{Sensor: map[string]SensorConfig{
+		"28-000000000001": {Name: "loft"},
+		"bme280":          {Name: "hallway"},
+	}}, testSensorsPaths(t), nil)
+	checkLines(t, gatherLines(t, sc, "host_sensor_"), []string{
+		`counter host_sensor_read_errors{device="28-000000000002",name="28-000000000002"} 1`,
+		`counter host_sensor_read_errors{device="28-000000000003",name="28-000000000003"} 1`,
+		`gauge host_sensor_humidity_ratio{device="iio:device0",name="hallway"} 0.45123`,
+		`gauge host_sensor_humidity_ratio{device="iio:device1",name="iio:device1"} 0.45`,
+		`gauge host_sensor_pressure_pascals{device="iio:device0",name="hallway"} 101325`,
+		`gauge host_sensor_temp_celsius{device="28-000000000001",name="loft"} 23.125`,
+		`gauge host_sensor_temp_celsius{device="28-000000000004",name="28-000000000004"} 19.5`,
+		`gauge host_sensor_temp_celsius{device="iio:device0",name="hallway"} 21.37`,
+		`gauge host_sensor_temp_celsius{device="iio:device1",name="iio:device1"} 20`,
+	})
+}
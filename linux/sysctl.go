@@ -0,0 +1,136 @@
+package linux
+
+import (
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the procfs root.
+	fileNrPath         = "sys/fs/file-nr"
+	entropyAvailPath   = "sys/kernel/random/entropy_avail"
+	conntrackCountPath = "sys/net/netfilter/nf_conntrack_count"
+	conntrackMaxPath   = "sys/net/netfilter/nf_conntrack_max"
+)
+
+type fileNrCollector struct {
+	metrics   util.MetricCollection
+	paths     sysPaths
+	allocated promm.Gauge
+	max       promm.Gauge
+}
+
+func newFileNrCollector(paths sysPaths, labels promm.Labels) *fileNrCollector {
+	fc := &fileNrCollector{paths: paths}
+	fc.allocated = fc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "file_handles_allocated",
+		Help:        "File handles allocated by the kernel (count).",
+		ConstLabels: labels,
+	})
+	fc.max = fc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "file_handles_max",
+		Help:        "Maximum file handles that the kernel will allocate (count).",
+		ConstLabels: labels,
+	})
+	return fc
+}
+
+func (fc *fileNrCollector) Describe(ch chan<- *promm.Desc) {
+	fc.metrics.Describe(ch)
+}
+
+func (fc *fileNrCollector) Collect(ch chan<- promm.Metric) {
+	if err := fc.readStats(); err != nil {
+		log.Printf("Error reading file handle counts: %v", err)
+	}
+	fc.metrics.Collect(ch)
+}
+
+func (fc *fileNrCollector) readStats() error {
+	// Of the form "1024	0	9223372036854775807", being the allocated, unused
+	// (always zero since Linux 2.6) and maximum file handles.
+	values, err := readFields(fc.paths.proc(fileNrPath), 3)
+	if err != nil {
+		return err
+	}
+	for _, v := range []struct {
+		g     promm.Gauge
+		value string
+	}{
+		{fc.allocated, values[0]},
+		{fc.max, values[2]},
+	} {
+		count, err := strconv.ParseUint(v.value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.g.Set(float64(count))
+	}
+	return nil
+}
+
+type entropyCollector struct {
+	metrics   util.MetricCollection
+	paths     sysPaths
+	available promm.Gauge
+}
+
+func newEntropyCollector(paths sysPaths, labels promm.Labels) *entropyCollector {
+	ec := &entropyCollector{paths: paths}
+	ec.available = ec.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "entropy_available_bits",
+		Help:        "Entropy available in the kernel's random pool (bits).",
+		ConstLabels: labels,
+	})
+	return ec
+}
+
+func (ec *entropyCollector) Describe(ch chan<- *promm.Desc) {
+	ec.metrics.Describe(ch)
+}
+
+func (ec *entropyCollector) Collect(ch chan<- promm.Metric) {
+	readIntFileIntoGauge(ec.available, ec.paths.proc(entropyAvailPath))
+	ec.metrics.Collect(ch)
+}
+
+type conntrackCollector struct {
+	metrics util.MetricCollection
+	paths   sysPaths
+	count   promm.Gauge
+	max     promm.Gauge
+}
+
+func newConntrackCollector(paths sysPaths, labels promm.Labels) *conntrackCollector {
+	cc := &conntrackCollector{paths: paths}
+	cc.count = cc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "conntrack_entries",
+		Help:        "Connections tracked by netfilter (count).",
+		ConstLabels: labels,
+	})
+	cc.max = cc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "conntrack_entries_max",
+		Help:        "Maximum connections that netfilter will track, beyond which new connections are dropped (count).",
+		ConstLabels: labels,
+	})
+	return cc
+}
+
+func (cc *conntrackCollector) Describe(ch chan<- *promm.Desc) {
+	cc.metrics.Describe(ch)
+}
+
+func (cc *conntrackCollector) Collect(ch chan<- promm.Metric) {
+	// The files only exist while the nf_conntrack module is loaded, in which
+	// case there is nothing to report.
+	if _, err := os.Stat(cc.paths.proc(conntrackCountPath)); err != nil {
+		return
+	}
+	readIntFileIntoGauge(cc.count, cc.paths.proc(conntrackCountPath))
+	readIntFileIntoGauge(cc.max, cc.paths.proc(conntrackMaxPath))
+	cc.metrics.Collect(ch)
+}
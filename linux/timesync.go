@@ -0,0 +1,336 @@
+package linux
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	timeSyncDaemonChrony   = "chrony"
+	timeSyncDaemonNtpd     = "ntpd"
+	timeSyncDefaultTimeout = 5 * time.Second
+	// Clock state returned by adjtimex when the clock is not synchronised.
+	adjtimexTimeError = 5
+	// Bits of Timex.Status, from <sys/timex.h>.
+	adjtimexStaUnsync = 0x0040
+	adjtimexStaNano   = 0x2000
+)
+
+var (
+	timeSyncDefaultCommands = map[string][]string{
+		timeSyncDaemonChrony: {"chronyc", "-c", "tracking"},
+		timeSyncDaemonNtpd:   {"ntpq", "-c", "rv"},
+	}
+)
+
+type TimeSyncConfig struct {
+	// Time synchronisation daemon to also query: "chrony" or "ntpd". The
+	// kernel's clock state is always exported.
+	Daemon string
+	// Command that queries the daemon. Defaults to
+	// ["chronyc", "-c", "tracking"] for chrony, and ["ntpq", "-c", "rv"] for
+	// ntpd.
+	Command []string
+	// Time to wait for the command. Defaults to 5 seconds.
+	Timeout util.Duration
+}
+
+// Values reported by a time synchronisation daemon.
+type timeSyncStatus struct {
+	offsetSeconds         float64
+	frequencyPPM          float64
+	stratum               float64
+	rootDelaySeconds      float64
+	rootDispersionSeconds float64
+	synchronised          bool
+}
+
+type timeSyncCollector struct {
+	metrics util.MetricCollection
+	daemon  string
+	command []string
+	timeout time.Duration
+
+	// Kernel clock state, from adjtimex:
+	offset       promm.Gauge
+	frequency    promm.Gauge
+	maxError     promm.Gauge
+	estError     promm.Gauge
+	synchronised promm.Gauge
+	taiOffset    promm.Gauge
+	// Daemon state:
+	daemonOffset         *promm.GaugeVec
+	daemonFrequency      *promm.GaugeVec
+	daemonStratum        *promm.GaugeVec
+	daemonRootDelay      *promm.GaugeVec
+	daemonRootDispersion *promm.GaugeVec
+	daemonSynchronised   *promm.GaugeVec
+}
+
+func newTimeSyncCollector(cfg TimeSyncConfig, labels promm.Labels) (*timeSyncCollector, error) {
+	tc := &timeSyncCollector{
+		daemon:  cfg.Daemon,
+		command: cfg.Command,
+		timeout: cfg.Timeout.Duration,
+	}
+	if tc.daemon != "" {
+		defaultCommand, ok := timeSyncDefaultCommands[tc.daemon]
+		if !ok {
+			return nil, fmt.Errorf("unknown time sync daemon %q, accepted values: %s, %s",
+				tc.daemon, timeSyncDaemonChrony, timeSyncDaemonNtpd)
+		}
+		if len(tc.command) == 0 {
+			tc.command = defaultCommand
+		}
+	}
+	if tc.timeout == 0 {
+		tc.timeout = timeSyncDefaultTimeout
+	}
+
+	tc.offset = tc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "timex_offset_seconds",
+		Help:        "Offset of the kernel clock still to be corrected (seconds).",
+		ConstLabels: labels,
+	})
+	tc.frequency = tc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "timex_frequency_adjustment_ratio",
+		Help:        "Frequency adjustment of the kernel clock (ratio).",
+		ConstLabels: labels,
+	})
+	tc.maxError = tc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "timex_maxerror_seconds",
+		Help:        "Maximum error of the kernel clock (seconds).",
+		ConstLabels: labels,
+	})
+	tc.estError = tc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "timex_estimated_error_seconds",
+		Help:        "Estimated error of the kernel clock (seconds).",
+		ConstLabels: labels,
+	})
+	tc.synchronised = tc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "timex_sync_status",
+		Help:        "1 if the kernel clock is synchronised, 0 otherwise.",
+		ConstLabels: labels,
+	})
+	tc.taiOffset = tc.metrics.NewGauge(promm.GaugeOpts{
+		Namespace: namespace, Name: "timex_tai_offset_seconds",
+		Help:        "Offset of TAI from UTC known to the kernel (seconds).",
+		ConstLabels: labels,
+	})
+
+	if tc.daemon != "" {
+		tc.daemonOffset = tc.metrics.NewGaugeVec(
+			promm.GaugeOpts{
+				Namespace: namespace, Name: "timesync_offset_seconds",
+				Help:        "Offset of the system clock from the time sync daemon's reference (seconds).",
+				ConstLabels: labels,
+			},
+			[]string{"daemon"},
+		)
+		tc.daemonFrequency = tc.metrics.NewGaugeVec(
+			promm.GaugeOpts{
+				Namespace: namespace, Name: "timesync_frequency_ratio",
+				Help:        "Frequency error of the system clock estimated by the time sync daemon (ratio).",
+				ConstLabels: labels,
+			},
+			[]string{"daemon"},
+		)
+		tc.daemonStratum = tc.metrics.NewGaugeVec(
+			promm.GaugeOpts{
+				Namespace: namespace, Name: "timesync_stratum",
+				Help:        "Stratum of the time sync daemon.",
+				ConstLabels: labels,
+			},
+			[]string{"daemon"},
+		)
+		tc.daemonRootDelay = tc.metrics.NewGaugeVec(
+			promm.GaugeOpts{
+				Namespace: namespace, Name: "timesync_root_delay_seconds",
+				Help:        "Network delay to the time sync daemon's stratum 1 source (seconds).",
+				ConstLabels: labels,
+			},
+			[]string{"daemon"},
+		)
+		tc.daemonRootDispersion = tc.metrics.NewGaugeVec(
+			promm.GaugeOpts{
+				Namespace: namespace, Name: "timesync_root_dispersion_seconds",
+				Help:        "Dispersion accumulated from the time sync daemon's stratum 1 source (seconds).",
+				ConstLabels: labels,
+			},
+			[]string{"daemon"},
+		)
+		tc.daemonSynchronised = tc.metrics.NewGaugeVec(
+			promm.GaugeOpts{
+				Namespace: namespace, Name: "timesync_synchronised",
+				Help:        "1 if the time sync daemon is synchronised, 0 otherwise.",
+				ConstLabels: labels,
+			},
+			[]string{"daemon"},
+		)
+	}
+	return tc, nil
+}
+
+func (tc *timeSyncCollector) Describe(ch chan<- *promm.Desc) {
+	tc.metrics.Describe(ch)
+}
+
+func (tc *timeSyncCollector) Collect(ch chan<- promm.Metric) {
+	if err := tc.readAdjtimex(); err != nil {
+		log.Printf("Error reading kernel clock state: %v", err)
+	}
+	if tc.daemon != "" {
+		if err := tc.readDaemon(); err != nil {
+			log.Printf("Error querying %s: %v", tc.daemon, err)
+		}
+	}
+	tc.metrics.Collect(ch)
+}
+
+func (tc *timeSyncCollector) readAdjtimex() error {
+	// Modes of zero only reads the clock state.
+	var timex syscall.Timex
+	state, err := syscall.Adjtimex(&timex)
+	if err != nil {
+		return err
+	}
+	// The offset is in microseconds, or nanoseconds if STA_NANO is set.
+	offsetScale := 1e-6
+	if timex.Status&adjtimexStaNano != 0 {
+		offsetScale = 1e-9
+	}
+	tc.offset.Set(float64(timex.Offset) * offsetScale)
+	// The frequency is in parts per million, with a 16 bit fractional part.
+	tc.frequency.Set(float64(timex.Freq) / 65536 / 1e6)
+	tc.maxError.Set(float64(timex.Maxerror) * 1e-6)
+	tc.estError.Set(float64(timex.Esterror) * 1e-6)
+	if state != adjtimexTimeError && timex.Status&adjtimexStaUnsync == 0 {
+		tc.synchronised.Set(1)
+	} else {
+		tc.synchronised.Set(0)
+	}
+	tc.taiOffset.Set(float64(timex.Tai))
+	return nil
+}
+
+func (tc *timeSyncCollector) readDaemon() error {
+	output, err := runCommand(tc.command, tc.timeout)
+	if err != nil {
+		return err
+	}
+	var status timeSyncStatus
+	switch tc.daemon {
+	case timeSyncDaemonChrony:
+		status, err = parseChronyTracking(output)
+	case timeSyncDaemonNtpd:
+		status, err = parseNtpqVariables(output)
+	}
+	if err != nil {
+		return err
+	}
+	labels := promm.Labels{"daemon": tc.daemon}
+	tc.daemonOffset.With(labels).Set(status.offsetSeconds)
+	tc.daemonFrequency.With(labels).Set(status.frequencyPPM / 1e6)
+	tc.daemonStratum.With(labels).Set(status.stratum)
+	tc.daemonRootDelay.With(labels).Set(status.rootDelaySeconds)
+	tc.daemonRootDispersion.With(labels).Set(status.rootDispersionSeconds)
+	if status.synchronised {
+		tc.daemonSynchronised.With(labels).Set(1)
+	} else {
+		tc.daemonSynchronised.With(labels).Set(0)
+	}
+	return nil
+}
+
+// Parses the output of "chronyc -c tracking", a CSV line of the form:
+// A9FEA97B,169.254.169.123,4,1690000000.123,0.000001234,-0.000002,0.000010,-12.345,0.001,0.020,0.000300,0.000100,64.2,Normal
+func parseChronyTracking(output []byte) (timeSyncStatus, error) {
+	var status timeSyncStatus
+	record, err := csv.NewReader(bytes.NewReader(output)).Read()
+	if err != nil {
+		return status, err
+	}
+	if len(record) < 14 {
+		return status, fmt.Errorf("expected at least 14 fields in chronyc tracking output, got %d", len(record))
+	}
+	// Column indexes of the values used.
+	const (
+		stratum        = 2
+		systemTime     = 4
+		frequency      = 7
+		rootDelay      = 10
+		rootDispersion = 11
+		leapStatus     = 13
+	)
+	for _, f := range []struct {
+		column int
+		value  *float64
+	}{
+		{stratum, &status.stratum},
+		{systemTime, &status.offsetSeconds},
+		{frequency, &status.frequencyPPM},
+		{rootDelay, &status.rootDelaySeconds},
+		{rootDispersion, &status.rootDispersionSeconds},
+	} {
+		if *f.value, err = strconv.ParseFloat(record[f.column], 64); err != nil {
+			return status, err
+		}
+	}
+	status.synchronised = record[leapStatus] != "Not synchronised"
+	return status, nil
+}
+
+// Parses the output of "ntpq -c rv", comma separated variables of the form
+// "offset=-0.123" spread over several lines.
+func parseNtpqVariables(output []byte) (timeSyncStatus, error) {
+	var status timeSyncStatus
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		for _, kv := range strings.Split(scanner.Text(), ",") {
+			eq := strings.IndexByte(kv, '=')
+			if eq < 0 {
+				continue
+			}
+			vars[strings.TrimSpace(kv[:eq])] = strings.Trim(strings.TrimSpace(kv[eq+1:]), `"`)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return status, err
+	}
+	// offset, rootdelay and rootdisp are in milliseconds.
+	for _, f := range []struct {
+		name  string
+		value *float64
+		scale float64
+	}{
+		{"stratum", &status.stratum, 1},
+		{"offset", &status.offsetSeconds, 1e-3},
+		{"frequency", &status.frequencyPPM, 1},
+		{"rootdelay", &status.rootDelaySeconds, 1e-3},
+		{"rootdisp", &status.rootDispersionSeconds, 1e-3},
+	} {
+		s, ok := vars[f.name]
+		if !ok {
+			return status, fmt.Errorf("missing %q in ntpq output", f.name)
+		}
+		value, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return status, err
+		}
+		*f.value = value * f.scale
+	}
+	// leap is "11" (alarm) when unsynchronised, and the stratum is 16.
+	status.synchronised = vars["leap"] != "11" && vars["leap"] != "3" && status.stratum < 16
+	return status, nil
+}
@@ -1,16 +1,28 @@
 package linux
 
 import (
+	"context"
 	"io/ioutil"
 	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/huin/warren/util"
 	promm "github.com/prometheus/client_golang/prometheus"
 )
 
+// Sets ctr to the integer read from path. Missing files are skipped without
+// logging, as they are typically attributes that the running kernel lacks.
 func readIntFileIntoGauge(ctr promm.Gauge, path string) {
 	value, err := readIntFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
 	if err != nil {
 		log.Printf("Unable to read integer from file %q for counter %v: %v",
 			path, *ctr.Desc(), err)
@@ -19,13 +31,41 @@ func readIntFileIntoGauge(ctr promm.Gauge, path string) {
 	ctr.Set(float64(value))
 }
 
+// Sets the gauge in vec with the given labels to the integer read from path.
+// Unlike readIntFileIntoGauge, the gauge is only created if path can be read,
+// so that missing attributes are not exported as zero.
+func readIntFileIntoGaugeVec(vec *promm.GaugeVec, labels promm.Labels, path string) {
+	value, err := readIntFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to read integer from file %q: %v", path, err)
+		return
+	}
+	vec.With(labels).Set(float64(value))
+}
+
+// As readIntFileIntoGaugeVec, but for a util.ValueVec.
+func readIntFileIntoValueVec(vec *util.ValueVec, labels promm.Labels, path string) {
+	value, err := readIntFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to read integer from file %q: %v", path, err)
+		return
+	}
+	vec.Set(labels, float64(value))
+}
+
 // Read a text file containing a single decimal integer. The number is assumed
 // to end at the first of: nul-zero byte, newline, or EOF. The file is read
 // into memory so should be short.
 func readIntFile(path string) (int64, error) {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		return 0, nil
+		return 0, err
 	}
 	s := string(data)
 	end := strings.IndexAny(s, intFileEnding)
@@ -34,3 +74,79 @@ func readIntFile(path string) (int64, error) {
 	}
 	return strconv.ParseInt(s[0:end], 10, 64)
 }
+
+// Selects names (e.g of devices) by include and exclude patterns.
+type nameFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+// Creates a nameFilter from re2 patterns. An empty include pattern includes
+// all names, and an empty exclude pattern is replaced by defaultExclude.
+func newNameFilter(include, exclude, defaultExclude string) (nameFilter, error) {
+	var f nameFilter
+	var err error
+	if include != "" {
+		if f.include, err = regexp.Compile(include); err != nil {
+			return nameFilter{}, err
+		}
+	}
+	if exclude == "" {
+		exclude = defaultExclude
+	}
+	if exclude != "" {
+		if f.exclude, err = regexp.Compile(exclude); err != nil {
+			return nameFilter{}, err
+		}
+	}
+	return f, nil
+}
+
+func (f nameFilter) match(name string) bool {
+	if f.include != nil && !f.include.MatchString(name) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(name)
+}
+
+// Read a short text file, such as a sysfs attribute, with surrounding
+// whitespace removed.
+func readStringFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Locations of the procfs and sysfs mounts that collectors read from, and of
+// the root filesystem. Paths in the collectors are relative to these.
+type sysPaths struct {
+	procRoot string
+	sysRoot  string
+	rootFs   string
+}
+
+// Returns the path of a file in procfs, joining elem to the procfs root.
+func (p sysPaths) proc(elem ...string) string {
+	return filepath.Join(append([]string{p.procRoot}, elem...)...)
+}
+
+// Returns the path of a file in sysfs, joining elem to the sysfs root.
+func (p sysPaths) sys(elem ...string) string {
+	return filepath.Join(append([]string{p.sysRoot}, elem...)...)
+}
+
+// Returns the path of a file in the root filesystem, joining elem to its
+// mount point.
+func (p sysPaths) root(elem ...string) string {
+	return filepath.Join(append([]string{p.rootFs}, elem...)...)
+}
+
+// Runs a command, given as the program and its arguments, returning its
+// standard output. The command is killed if it runs for longer than timeout.
+func runCommand(args []string, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return exec.CommandContext(ctx, args[0], args[1:]...).Output()
+}
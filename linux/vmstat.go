@@ -0,0 +1,116 @@
+package linux
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the procfs root.
+	vmStatPath = "vmstat"
+)
+
+var (
+	// Event counters in /proc/vmstat that can be exported. Not all fields are
+	// present in all kernel versions.
+	vmStatFields = []string{
+		"pgpgin", "pgpgout", "pswpin", "pswpout", "pgfault", "pgmajfault",
+		"pgfree", "pgactivate", "pgdeactivate", "pgsteal_kswapd",
+		"pgsteal_direct", "pgscan_kswapd", "pgscan_direct", "allocstall_normal",
+		"allocstall_movable", "compact_stall", "compact_fail", "compact_success",
+		"thp_fault_alloc", "thp_fault_fallback", "oom_kill",
+	}
+)
+
+type VmStatConfig struct {
+	// /proc/vmstat fields to export values for. See the (private) vmStatFields
+	// variable for allowed values.
+	Fields []string
+}
+
+type vmStatCollector struct {
+	metrics util.MetricCollection
+	paths   sysPaths
+	counts  *util.ValueVec
+	// Fields to export, from VmStatConfig.Fields.
+	fields map[string]struct{}
+}
+
+func newVmStatCollector(cfg VmStatConfig, paths sysPaths, labels promm.Labels) (*vmStatCollector, error) {
+	vc := &vmStatCollector{
+		paths:  paths,
+		fields: make(map[string]struct{}, len(cfg.Fields)),
+	}
+
+	for _, field := range cfg.Fields {
+		found := false
+		for _, knownField := range vmStatFields {
+			if knownField == field {
+				vc.fields[field] = struct{}{}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown vmstat field %q, accepted values: %s",
+				field, strings.Join(vmStatFields, ", "))
+		}
+	}
+
+	vc.counts = vc.metrics.NewValueVec(
+		promm.Opts{
+			Namespace: namespace, Name: "vmstat_count",
+			Help:        "Virtual memory event counts from /proc/vmstat, by field (count).",
+			ConstLabels: labels,
+		},
+		promm.CounterValue,
+		[]string{"field"},
+	)
+	return vc, nil
+}
+
+func (vc *vmStatCollector) Describe(ch chan<- *promm.Desc) {
+	vc.metrics.Describe(ch)
+}
+
+func (vc *vmStatCollector) Collect(ch chan<- promm.Metric) {
+	if len(vc.fields) == 0 {
+		return
+	}
+	if err := vc.readStats(); err != nil {
+		log.Printf("Error reading vmstat: %v", err)
+	}
+	vc.metrics.Collect(ch)
+}
+
+func (vc *vmStatCollector) readStats() error {
+	f, err := os.Open(vc.paths.proc(vmStatPath))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines are of the form "pgfault 123456".
+		values := strings.Fields(scanner.Text())
+		if len(values) != 2 {
+			continue
+		}
+		if _, ok := vc.fields[values[0]]; !ok {
+			continue
+		}
+		value, err := strconv.ParseUint(values[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		vc.counts.Set(promm.Labels{"field": values[0]}, float64(value))
+	}
+	return scanner.Err()
+}
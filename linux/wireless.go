@@ -0,0 +1,311 @@
+package linux
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the procfs root.
+	netWirelessPath = "net/wireless"
+	// Replaced by the interface name in WirelessConfig.StationCommand.
+	stationCommandInterface = "{interface}"
+	stationDefaultTimeout   = 5 * time.Second
+	stationDefaultCacheFor  = 10 * time.Second
+	// /proc/net/wireless reports this noise level when it is unknown.
+	wirelessNoiseUnknown = -256
+)
+
+var (
+	stationDefaultCommand = []string{"iw", "dev", stationCommandInterface, "station", "dump"}
+	// Reasons for discarding packets, in column order in /proc/net/wireless,
+	// following the quality columns.
+	wirelessDiscardReasons = []string{"nwid", "crypt", "frag", "retry", "misc"}
+	// Matches the first line of a station in "iw station dump" output, e.g
+	// "Station 12:34:56:78:9a:bc (on wlan0)".
+	stationRe = regexp.MustCompile(`^Station ([0-9a-fA-F:]+)`)
+	// Matches a bitrate, e.g "65.0 MBit/s MCS 7".
+	stationBitrateRe = regexp.MustCompile(`^([\d.]+) MBit/s`)
+)
+
+type WirelessConfig struct {
+	// Interfaces to report connected stations for, e.g access point
+	// interfaces. Stations are not reported if empty.
+	StationInterfaces []string `toml:"station_interfaces"`
+	// Command that prints the stations of an interface in the format of
+	// "iw dev <interface> station dump". Arguments of "{interface}" are
+	// replaced by the interface name. Defaults to
+	// ["iw", "dev", "{interface}", "station", "dump"].
+	StationCommand []string `toml:"station_command"`
+	// Time to wait for the station command. Defaults to 5 seconds.
+	StationTimeout util.Duration `toml:"station_timeout"`
+	// How long to reuse the stations from the station command for, rather than
+	// running it on every collection. Defaults to 10 seconds.
+	StationCacheFor util.Duration `toml:"station_cache_for"`
+}
+
+type wirelessCollector struct {
+	metrics           util.MetricCollection
+	paths             sysPaths
+	stationInterfaces []string
+	stationCommand    []string
+	stationTimeout    time.Duration
+	stationCacheFor   time.Duration
+
+	linkQuality   *promm.GaugeVec
+	signal        *promm.GaugeVec
+	noise         *promm.GaugeVec
+	discarded     *util.ValueVec
+	missedBeacons *util.ValueVec
+	stationSignal *promm.GaugeVec
+	stationTxRate *promm.GaugeVec
+	stationRxRate *promm.GaugeVec
+	stationUptime *promm.GaugeVec
+
+	// Guards stationsRead and the station metrics, which are reset on reread.
+	mu sync.Mutex
+	// Time that the stations were last read.
+	stationsRead time.Time
+}
+
+func newWirelessCollector(cfg WirelessConfig, paths sysPaths, labels promm.Labels) *wirelessCollector {
+	wc := &wirelessCollector{
+		paths:             paths,
+		stationInterfaces: cfg.StationInterfaces,
+		stationCommand:    cfg.StationCommand,
+		stationTimeout:    cfg.StationTimeout.Duration,
+		stationCacheFor:   cfg.StationCacheFor.Duration,
+	}
+	if len(wc.stationCommand) == 0 {
+		wc.stationCommand = stationDefaultCommand
+	}
+	if wc.stationCacheFor == 0 {
+		wc.stationCacheFor = stationDefaultCacheFor
+	}
+	if wc.stationTimeout == 0 {
+		wc.stationTimeout = stationDefaultTimeout
+	}
+
+	wc.linkQuality = wc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "wireless_link_quality",
+			Help:        "Link quality of wireless interface, on a driver-specific scale.",
+			ConstLabels: labels,
+		},
+		[]string{"interface"},
+	)
+	wc.signal = wc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "wireless_signal_dbm",
+			Help:        "Signal level of wireless interface (dBm).",
+			ConstLabels: labels,
+		},
+		[]string{"interface"},
+	)
+	wc.noise = wc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "wireless_noise_dbm",
+			Help:        "Noise level of wireless interface, where known (dBm).",
+			ConstLabels: labels,
+		},
+		[]string{"interface"},
+	)
+	wc.discarded = wc.metrics.NewValueVec(
+		promm.Opts{
+			Namespace: namespace, Name: "wireless_discarded_packets",
+			Help:        "Packets discarded by wireless interface, by reason (count).",
+			ConstLabels: labels,
+		},
+		promm.CounterValue,
+		[]string{"interface", "reason"},
+	)
+	wc.missedBeacons = wc.metrics.NewValueVec(
+		promm.Opts{
+			Namespace: namespace, Name: "wireless_missed_beacons",
+			Help:        "Beacons missed by wireless interface (count).",
+			ConstLabels: labels,
+		},
+		promm.CounterValue,
+		[]string{"interface"},
+	)
+	wc.stationSignal = wc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "wireless_station_signal_dbm",
+			Help:        "Signal level of station connected to wireless interface (dBm).",
+			ConstLabels: labels,
+		},
+		[]string{"interface", "station"},
+	)
+	wc.stationTxRate = wc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "wireless_station_tx_bitrate_bytes",
+			Help:        "Bitrate of last transmission to station connected to wireless interface (bytes per second).",
+			ConstLabels: labels,
+		},
+		[]string{"interface", "station"},
+	)
+	wc.stationRxRate = wc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "wireless_station_rx_bitrate_bytes",
+			Help:        "Bitrate of last reception from station connected to wireless interface (bytes per second).",
+			ConstLabels: labels,
+		},
+		[]string{"interface", "station"},
+	)
+	wc.stationUptime = wc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "wireless_station_connected_seconds",
+			Help:        "Time that station has been connected to wireless interface (seconds).",
+			ConstLabels: labels,
+		},
+		[]string{"interface", "station"},
+	)
+	return wc
+}
+
+func (wc *wirelessCollector) Describe(ch chan<- *promm.Desc) {
+	wc.metrics.Describe(ch)
+}
+
+func (wc *wirelessCollector) Collect(ch chan<- promm.Metric) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	if err := wc.readStats(); err != nil {
+		log.Printf("Error reading wireless stats: %v", err)
+	}
+	if len(wc.stationInterfaces) > 0 && time.Since(wc.stationsRead) >= wc.stationCacheFor {
+		wc.stationsRead = time.Now()
+		// Stations come and go.
+		wc.stationSignal.Reset()
+		wc.stationTxRate.Reset()
+		wc.stationRxRate.Reset()
+		wc.stationUptime.Reset()
+		for _, iface := range wc.stationInterfaces {
+			if err := wc.readStations(iface); err != nil {
+				log.Printf("Error reading wireless stations of %s: %v", iface, err)
+			}
+		}
+	}
+	wc.metrics.Collect(ch)
+}
+
+func (wc *wirelessCollector) readStats() error {
+	f, err := os.Open(wc.paths.proc(netWirelessPath))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// After two header lines, lines are of the form:
+		// wlan0: 0000   70.  -40.  -256        0      0      0      0      0        0
+		// The columns are status, quality (link, level, noise), discarded
+		// packets by reason, and missed beacons.
+		l := scanner.Text()
+		colon := strings.IndexByte(l, ':')
+		if colon < 0 {
+			continue
+		}
+		iface := strings.TrimSpace(l[:colon])
+		values := strings.Fields(l[colon+1:])
+		if len(values) < 4+len(wirelessDiscardReasons)+1 {
+			continue
+		}
+		labels := promm.Labels{"interface": iface}
+		// Quality values have a trailing "." if updated since last read.
+		quality := make([]float64, 3)
+		for i := range quality {
+			if quality[i], err = strconv.ParseFloat(strings.TrimSuffix(values[1+i], "."), 64); err != nil {
+				return fmt.Errorf("malformed quality for %s: %v", iface, err)
+			}
+		}
+		wc.linkQuality.With(labels).Set(quality[0])
+		wc.signal.With(labels).Set(quality[1])
+		if quality[2] != wirelessNoiseUnknown {
+			wc.noise.With(labels).Set(quality[2])
+		}
+		for i, reason := range wirelessDiscardReasons {
+			if count, err := strconv.ParseUint(values[4+i], 10, 64); err == nil {
+				wc.discarded.Set(promm.Labels{"interface": iface, "reason": reason}, float64(count))
+			}
+		}
+		if count, err := strconv.ParseUint(values[4+len(wirelessDiscardReasons)], 10, 64); err == nil {
+			wc.missedBeacons.Set(labels, float64(count))
+		}
+	}
+	return scanner.Err()
+}
+
+func (wc *wirelessCollector) readStations(iface string) error {
+	args := make([]string, len(wc.stationCommand))
+	for i, arg := range wc.stationCommand {
+		if arg == stationCommandInterface {
+			arg = iface
+		}
+		args[i] = arg
+	}
+	output, err := runCommand(args, wc.stationTimeout)
+	if err != nil {
+		return err
+	}
+
+	var labels promm.Labels
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		l := scanner.Text()
+		if m := stationRe.FindStringSubmatch(l); m != nil {
+			labels = promm.Labels{"interface": iface, "station": strings.ToLower(m[1])}
+			continue
+		}
+		if labels == nil {
+			continue
+		}
+		// Station properties are of the form "\tsignal:  \t-55 [-55] dBm".
+		colon := strings.IndexByte(l, ':')
+		if colon < 0 {
+			continue
+		}
+		key := strings.TrimSpace(l[:colon])
+		value := strings.TrimSpace(l[colon+1:])
+		switch key {
+		case "signal":
+			if fields := strings.Fields(value); len(fields) > 0 {
+				if dbm, err := strconv.ParseFloat(fields[0], 64); err == nil {
+					wc.stationSignal.With(labels).Set(dbm)
+				}
+			}
+		case "tx bitrate", "rx bitrate":
+			m := stationBitrateRe.FindStringSubmatch(value)
+			if m == nil {
+				continue
+			}
+			mbps, err := strconv.ParseFloat(m[1], 64)
+			if err != nil {
+				continue
+			}
+			vec := wc.stationTxRate
+			if key == "rx bitrate" {
+				vec = wc.stationRxRate
+			}
+			vec.With(labels).Set(mbps * 1e6 / 8)
+		case "connected time":
+			if fields := strings.Fields(value); len(fields) > 0 {
+				if seconds, err := strconv.ParseFloat(fields[0], 64); err == nil {
+					wc.stationUptime.With(labels).Set(seconds)
+				}
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,162 @@
+package linux
+
+import (
+	"bufio"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Relative to the procfs root.
+	zfsKstatPath = "spl/kstat/zfs"
+	// Relative to zfsKstatPath.
+	zfsArcStatsPath = "arcstats"
+	// Relative to a pool's directory in zfsKstatPath.
+	zfsPoolStatePath = "state"
+)
+
+var (
+	// ARC statistics in arcstats that are counts of events.
+	zfsArcCounts = []string{
+		"hits", "misses", "demand_data_hits", "demand_data_misses",
+		"demand_metadata_hits", "demand_metadata_misses", "prefetch_data_hits",
+		"prefetch_data_misses", "l2_hits", "l2_misses", "evict_skip",
+		"memory_throttle_count",
+	}
+	// ARC statistics in arcstats that are current sizes in bytes.
+	zfsArcSizes = []string{
+		"size", "c", "c_min", "c_max", "data_size", "metadata_size", "mru_size",
+		"mfu_size", "l2_size", "l2_asize",
+	}
+)
+
+type zfsCollector struct {
+	metrics   util.MetricCollection
+	paths     sysPaths
+	arcCounts *util.ValueVec
+	arcSizes  *promm.GaugeVec
+	poolState *promm.GaugeVec
+	// Kinds of ARC statistic, as in zfsArcCounts and zfsArcSizes.
+	arcStats map[string]bool
+
+	// Pool states are reset and refilled by readPoolStates.
+	mu sync.Mutex
+}
+
+func newZfsCollector(paths sysPaths, labels promm.Labels) *zfsCollector {
+	zc := &zfsCollector{
+		paths:    paths,
+		arcStats: make(map[string]bool, len(zfsArcCounts)+len(zfsArcSizes)),
+	}
+	for _, stat := range zfsArcCounts {
+		zc.arcStats[stat] = true
+	}
+	for _, stat := range zfsArcSizes {
+		zc.arcStats[stat] = false
+	}
+	zc.arcCounts = zc.metrics.NewValueVec(
+		promm.Opts{
+			Namespace: namespace, Name: "zfs_arc_count",
+			Help:        "ZFS adaptive replacement cache event counts, by statistic (count).",
+			ConstLabels: labels,
+		},
+		promm.CounterValue,
+		[]string{"stat"},
+	)
+	zc.arcSizes = zc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "zfs_arc_bytes",
+			Help:        "ZFS adaptive replacement cache sizes, by statistic (bytes).",
+			ConstLabels: labels,
+		},
+		[]string{"stat"},
+	)
+	zc.poolState = zc.metrics.NewGaugeVec(
+		promm.GaugeOpts{
+			Namespace: namespace, Name: "zfs_pool_state",
+			Help:        "State of ZFS pool (e.g ONLINE or DEGRADED), 1 for the current state.",
+			ConstLabels: labels,
+		},
+		[]string{"pool", "state"},
+	)
+	return zc
+}
+
+func (zc *zfsCollector) Describe(ch chan<- *promm.Desc) {
+	zc.metrics.Describe(ch)
+}
+
+func (zc *zfsCollector) Collect(ch chan<- promm.Metric) {
+	zc.mu.Lock()
+	defer zc.mu.Unlock()
+	if err := zc.readArcStats(); err != nil {
+		log.Printf("Error reading ZFS ARC stats: %v", err)
+	}
+	if err := zc.readPoolStates(); err != nil {
+		log.Printf("Error reading ZFS pool states: %v", err)
+	}
+	zc.metrics.Collect(ch)
+}
+
+func (zc *zfsCollector) readArcStats() error {
+	f, err := os.Open(zc.paths.proc(zfsKstatPath, zfsArcStatsPath))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// After two header lines, lines are of the form "hits  4  1234", the
+		// columns being name, type and data.
+		values := strings.Fields(scanner.Text())
+		if len(values) != 3 {
+			continue
+		}
+		isCount, ok := zc.arcStats[values[0]]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseUint(values[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		labels := promm.Labels{"stat": values[0]}
+		if isCount {
+			zc.arcCounts.Set(labels, float64(value))
+		} else {
+			zc.arcSizes.With(labels).Set(float64(value))
+		}
+	}
+	return scanner.Err()
+}
+
+func (zc *zfsCollector) readPoolStates() error {
+	kstatDir := zc.paths.proc(zfsKstatPath)
+	entries, err := ioutil.ReadDir(kstatDir)
+	if err != nil {
+		return err
+	}
+	// Pools come and go, and change state.
+	zc.poolState.Reset()
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		// Directories without a state file are not pools, or are pools on
+		// versions of ZFS that do not report their state.
+		state, err := readStringFile(filepath.Join(kstatDir, entry.Name(), zfsPoolStatePath))
+		if err != nil {
+			continue
+		}
+		zc.poolState.With(promm.Labels{"pool": entry.Name(), "state": state}).Set(1)
+	}
+	return nil
+}
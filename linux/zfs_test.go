@@ -0,0 +1,27 @@
+package linux
+
+import "testing"
+
+func TestZfs(t *testing.T) {
+	zc := newZfsCollector(testPaths, nil)
+	// Unknown arcstats (compressed_size) and other kstat files (fm, a pool's
+	// io) are ignored.
+	checkLines(t, gatherLines(t, zc, "host_zfs_"), []string{
+		`counter host_zfs_arc_count{stat="demand_data_hits"} 1000`,
+		`counter host_zfs_arc_count{stat="hits"} 123456`,
+		`counter host_zfs_arc_count{stat="memory_throttle_count"} 0`,
+		`counter host_zfs_arc_count{stat="misses"} 789`,
+		`gauge host_zfs_arc_bytes{stat="c"} 2147483648`,
+		`gauge host_zfs_arc_bytes{stat="c_max"} 8589934592`,
+		`gauge host_zfs_arc_bytes{stat="mru_size"} 536870912`,
+		`gauge host_zfs_arc_bytes{stat="size"} 1073741824`,
+		`gauge host_zfs_pool_state{pool="backup",state="DEGRADED"} 1`,
+		`gauge host_zfs_pool_state{pool="tank",state="ONLINE"} 1`,
+	})
+}
+
+func TestZfsMissing(t *testing.T) {
+	// Without ZFS loaded, nothing is exported.
+	zc := newZfsCollector(sysPaths{procRoot: "testdata/mdstat/degraded"}, nil)
+	checkLines(t, gatherLines(t, zc, "host_zfs_"), nil)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+const (
+	// From linux/prctl.h.
+	prSetNoNewPrivs = 38
+	prGetNoNewPrivs = 39
+)
+
+var errNoNewPrivsCgo = errors.New("no_new_privs cannot be set on all threads of a binary that uses cgo, " +
+	"rebuild warren with CGO_ENABLED=0 or disable no_new_privs")
+
+// checkNoNewPrivs returns an error if dropPrivileges cannot set no_new_privs,
+// so that this can be reported before anything is opened. The flag is
+// per-thread, so is set on every thread by AllThreadsSyscall, which the
+// runtime does not support if it was started by cgo (as the default build of
+// os/user and net is).
+func checkNoNewPrivs() error {
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prGetNoNewPrivs, 0, 0); errno != 0 {
+		if errno == syscall.ENOTSUP {
+			return errNoNewPrivsCgo
+		}
+		return fmt.Errorf("prctl(PR_GET_NO_NEW_PRIVS): %v", errno)
+	}
+	return nil
+}
+
+// dropPrivileges switches the process to the given user and/or group. Empty
+// names leave the respective ID unchanged. If only a user is given, then the
+// user's primary group is used. If a user is given, then the process also takes
+// on the user's supplementary groups. Anything that needs privileges to open
+// must already have been opened by the time this is called.
+func dropPrivileges(userName, groupName string, noNewPrivs bool) error {
+	uid, gid := -1, -1
+	var groups []int
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return err
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return fmt.Errorf("bad uid %q for user %q: %v", u.Uid, userName, err)
+		}
+		groupIds, err := u.GroupIds()
+		if err != nil {
+			return fmt.Errorf("looking up groups of user %q: %v", userName, err)
+		}
+		for _, groupId := range groupIds {
+			id, err := strconv.Atoi(groupId)
+			if err != nil {
+				return fmt.Errorf("bad gid %q for user %q: %v", groupId, userName, err)
+			}
+			groups = append(groups, id)
+		}
+		if groupName == "" {
+			if gid, err = strconv.Atoi(u.Gid); err != nil {
+				return fmt.Errorf("bad gid %q for user %q: %v", u.Gid, userName, err)
+			}
+		}
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return fmt.Errorf("bad gid %q for group %q: %v", g.Gid, groupName, err)
+		}
+	}
+
+	// The group must change first, as doing so requires privileges that are lost
+	// when changing user.
+	if gid >= 0 {
+		if !containsInt(groups, gid) {
+			groups = append(groups, gid)
+		}
+		if err := syscall.Setgroups(groups); err != nil {
+			return fmt.Errorf("setgroups(%v): %v", groups, err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("setgid(%d): %v", gid, err)
+		}
+		log.Printf("Changed group to %d", gid)
+	}
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid(%d): %v", uid, err)
+		}
+		// Check that the change cannot be undone.
+		if uid != 0 && syscall.Setuid(0) == nil {
+			return fmt.Errorf("regained root privileges after setuid(%d)", uid)
+		}
+		log.Printf("Changed user to %d", uid)
+	}
+
+	if noNewPrivs {
+		// The flag is per-thread, and inherited by processes forked from that
+		// thread, so must be set on every thread of the process.
+		if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+			if errno == syscall.ENOTSUP {
+				return errNoNewPrivsCgo
+			}
+			return fmt.Errorf("prctl(PR_SET_NO_NEW_PRIVS): %v", errno)
+		}
+	}
+	return nil
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
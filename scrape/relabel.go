@@ -0,0 +1,108 @@
+package scrape
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	// Pseudo-label that refers to the metric name when relabeling.
+	nameLabel = "__name__"
+)
+
+// Configures a relabeling rule, similar to Prometheus' metric_relabel_configs.
+type RelabelConfig struct {
+	// One of:
+	// "replace" (default) - sets TargetLabel to Replacement if Regex matches
+	//   the value of SourceLabel.
+	// "keep" - drops metrics where Regex does not match the value of
+	//   SourceLabel.
+	// "drop" - drops metrics where Regex matches the value of SourceLabel.
+	// "labeldrop" - removes labels whose names match Regex.
+	Action string
+	// The label to match Regex against. "__name__" is the metric name.
+	SourceLabel string `toml:"source_label"`
+	// re2 pattern, which must match the entire value. Defaults to "(.*)".
+	Regex string
+	// The label to set with the "replace" action. Setting a label to an empty
+	// value removes it.
+	TargetLabel string `toml:"target_label"`
+	// Value for TargetLabel with the "replace" action. Subgroups of Regex are
+	// expanded, see https://golang.org/pkg/regexp/#Regexp.Expand for details.
+	// Defaults to "$1" if unset. An empty value removes TargetLabel.
+	Replacement *string
+}
+
+type relabeler struct {
+	action      string
+	sourceLabel string
+	re          *regexp.Regexp
+	targetLabel string
+	replacement string
+}
+
+func newRelabeler(cfg RelabelConfig) (relabeler, error) {
+	r := relabeler{
+		action:      cfg.Action,
+		sourceLabel: cfg.SourceLabel,
+		targetLabel: cfg.TargetLabel,
+		replacement: "$1",
+	}
+	if cfg.Replacement != nil {
+		r.replacement = *cfg.Replacement
+	}
+	if r.action == "" {
+		r.action = "replace"
+	}
+	pattern := cfg.Regex
+	if pattern == "" {
+		pattern = "(.*)"
+	}
+	var err error
+	if r.re, err = regexp.Compile("^(?:" + pattern + ")$"); err != nil {
+		return relabeler{}, err
+	}
+	switch r.action {
+	case "replace":
+		if r.targetLabel == "" {
+			return relabeler{}, fmt.Errorf("missing target_label for action %q", r.action)
+		}
+		if r.targetLabel == nameLabel {
+			return relabeler{}, fmt.Errorf("cannot relabel %s", nameLabel)
+		}
+		fallthrough
+	case "keep", "drop":
+		if r.sourceLabel == "" {
+			return relabeler{}, fmt.Errorf("missing source_label for action %q", r.action)
+		}
+	case "labeldrop":
+	default:
+		return relabeler{}, fmt.Errorf("unknown relabel action %q", r.action)
+	}
+	return r, nil
+}
+
+// apply relabels labels in place, returning false if the metric should be
+// dropped.
+func (r *relabeler) apply(labels map[string]string) bool {
+	switch r.action {
+	case "replace":
+		value := labels[r.sourceLabel]
+		match := r.re.FindStringSubmatchIndex(value)
+		if match == nil {
+			return true
+		}
+		labels[r.targetLabel] = string(r.re.ExpandString(nil, r.replacement, value, match))
+	case "keep":
+		return r.re.MatchString(labels[r.sourceLabel])
+	case "drop":
+		return !r.re.MatchString(labels[r.sourceLabel])
+	case "labeldrop":
+		for k := range labels {
+			if k != nameLabel && r.re.MatchString(k) {
+				delete(labels, k)
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,286 @@
+// Package scrape re-exports metrics scraped from other Prometheus exposition
+// endpoints, e.g from devices that are not directly reachable by the
+// monitoring server.
+package scrape
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	namespace = "warren"
+	subsystem = "scrape"
+
+	acceptHeader = `application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7,text/plain;version=0.0.4;q=0.3`
+)
+
+type Config struct {
+	// The URL of the exposition endpoint to scrape, e.g
+	// "http://192.168.1.20/metrics".
+	URL string
+	// How often to scrape URL. Defaults to 1 minute if unspecified.
+	Interval util.Duration
+	// Timeout for each scrape. Defaults to 10 seconds, or Interval if that is
+	// shorter.
+	Timeout util.Duration
+	// If a scrape fails, continue exporting the metrics from the last successful
+	// scrape until they are this old. Defaults to 0, which stops exporting them
+	// immediately.
+	CacheFor util.Duration `toml:"cache_for"`
+	// Relabeling rules, applied in order to each scraped metric.
+	Relabel []RelabelConfig
+	Labels  promm.Labels
+}
+
+// Collector implements prometheus.Collector for metrics scraped from an
+// exposition endpoint. The metrics are not known in advance, so Collector
+// does not describe them - this makes it an "unchecked" collector. Instead,
+// metric families are checked against those already exported via the given
+// prometheus.Gatherer, and conflicting families are dropped.
+type Collector struct {
+	cfg      Config
+	client   *http.Client
+	gatherer promm.Gatherer
+	relabel  []relabeler
+	meta     util.MetricCollection
+	up       promm.Gauge
+	duration promm.Gauge
+	timeouts promm.Counter
+	success  promm.Gauge
+	stale    promm.Gauge
+
+	mu sync.Mutex
+	// Metrics converted from the most recent successful scrape.
+	metrics []promm.Metric
+	// Series signatures of metrics.
+	series util.SeriesSet
+	// Time of the most recent successful scrape.
+	lastSuccess time.Time
+}
+
+func New(cfg Config, gatherer promm.Gatherer) (*Collector, error) {
+	c, err := newCollector(cfg, gatherer)
+	if err != nil {
+		return nil, err
+	}
+	go c.scrapeLoop()
+	return c, nil
+}
+
+// newCollector creates a Collector without starting to scrape.
+func newCollector(cfg Config, gatherer promm.Gatherer) (*Collector, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("missing URL in scrape config")
+	}
+	if cfg.Interval.Duration == 0 {
+		cfg.Interval.Duration = time.Minute
+	}
+	if cfg.Timeout.Duration == 0 {
+		cfg.Timeout.Duration = 10 * time.Second
+		if cfg.Interval.Duration < cfg.Timeout.Duration {
+			cfg.Timeout.Duration = cfg.Interval.Duration
+		}
+	}
+	relabel := make([]relabeler, 0, len(cfg.Relabel))
+	for i, rc := range cfg.Relabel {
+		r, err := newRelabeler(rc)
+		if err != nil {
+			return nil, fmt.Errorf("%v, relabel[%d] for %q", err, i, cfg.URL)
+		}
+		relabel = append(relabel, r)
+	}
+
+	urlLabels := promm.Labels{"url": cfg.URL}
+	for k, v := range cfg.Labels {
+		urlLabels[k] = v
+	}
+	var meta util.MetricCollection
+	c := &Collector{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: cfg.Timeout.Duration},
+		gatherer: gatherer,
+		relabel:  relabel,
+		up: meta.NewGauge(promm.GaugeOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "up",
+			Help:        "1 if the most recent scrape of the URL succeeded, 0 otherwise.",
+			ConstLabels: urlLabels,
+		}),
+		duration: meta.NewGauge(promm.GaugeOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "duration_seconds",
+			Help:        "Time taken by the most recent scrape of the URL. (seconds)",
+			ConstLabels: urlLabels,
+		}),
+		timeouts: meta.NewCounter(promm.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "timeouts_total",
+			Help:        "Count of scrapes of the URL that timed out. (count)",
+			ConstLabels: urlLabels,
+		}),
+		success: meta.NewGauge(promm.GaugeOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "last_success_timestamp_seconds",
+			Help:        "Time of the most recent successful scrape of the URL. (seconds since epoch)",
+			ConstLabels: urlLabels,
+		}),
+		stale: meta.NewGauge(promm.GaugeOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "stale",
+			Help:        "1 if the metrics exported for the URL are cached from an earlier scrape, 0 otherwise.",
+			ConstLabels: urlLabels,
+		}),
+	}
+	c.meta = meta
+	return c, nil
+}
+
+// Describe does not send any descriptors, see Collector.
+func (c *Collector) Describe(ch chan<- *promm.Desc) {}
+
+func (c *Collector) Collect(ch chan<- promm.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.meta.Collect(ch)
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
+
+func (c *Collector) scrapeLoop() {
+	c.scrape()
+	for range time.Tick(c.cfg.Interval.Duration) {
+		c.scrape()
+	}
+}
+
+// scrape fetches the metrics from the URL, replacing the previously scraped
+// metrics. If the scrape fails, then the previously scraped metrics continue
+// to be exported for up to Config.CacheFor.
+func (c *Collector) scrape() {
+	start := time.Now()
+	families, err := c.fetch()
+	c.duration.Set(time.Since(start).Seconds())
+	if err != nil {
+		log.Printf("Error scraping %q: %v", c.cfg.URL, err)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			c.timeouts.Inc()
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.up.Set(0)
+		if time.Since(c.lastSuccess) > c.cfg.CacheFor.Duration {
+			c.metrics = nil
+			c.series = nil
+			c.stale.Set(0)
+		} else if c.metrics != nil {
+			c.stale.Set(1)
+		}
+		return
+	}
+
+	c.mu.Lock()
+	own := c.series
+	c.mu.Unlock()
+	existing := util.GatherFamilies(c.gatherer, own)
+
+	var metrics []promm.Metric
+	series := util.SeriesSet{}
+	for _, mf := range families {
+		c.relabelFamily(mf)
+		fm, err := util.FamilyMetrics(mf, c.cfg.Labels)
+		if err == nil {
+			err = existing.Add(mf, fm, series)
+		}
+		if err != nil {
+			log.Printf("Ignoring metric %q scraped from %q: %v", mf.GetName(), c.cfg.URL, err)
+			continue
+		}
+		metrics = append(metrics, fm...)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metrics = metrics
+	c.series = series
+	c.lastSuccess = time.Now()
+	c.up.Set(1)
+	c.stale.Set(0)
+	c.success.Set(float64(c.lastSuccess.UnixNano()) / 1e9)
+}
+
+func (c *Collector) fetch() ([]*dto.MetricFamily, error) {
+	req, err := http.NewRequest(http.MethodGet, c.cfg.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", acceptHeader)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+
+	var families []*dto.MetricFamily
+	dec := expfmt.NewDecoder(resp.Body, expfmt.ResponseFormat(resp.Header))
+	for {
+		mf := new(dto.MetricFamily)
+		if err := dec.Decode(mf); err == io.EOF {
+			return families, nil
+		} else if err != nil {
+			return nil, err
+		}
+		families = append(families, mf)
+	}
+}
+
+// relabelFamily applies the relabeling rules to each metric in mf, removing
+// dropped metrics.
+func (c *Collector) relabelFamily(mf *dto.MetricFamily) {
+	if len(c.relabel) == 0 {
+		return
+	}
+	kept := mf.Metric[:0]
+	labels := map[string]string{}
+	for _, m := range mf.Metric {
+		for k := range labels {
+			delete(labels, k)
+		}
+		for _, lp := range m.Label {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		labels[nameLabel] = mf.GetName()
+
+		keep := true
+		for i := range c.relabel {
+			if keep = c.relabel[i].apply(labels); !keep {
+				break
+			}
+		}
+		if !keep {
+			continue
+		}
+
+		delete(labels, nameLabel)
+		m.Label = m.Label[:0]
+		for k, v := range labels {
+			if v == "" {
+				continue
+			}
+			name, value := k, v
+			m.Label = append(m.Label, &dto.LabelPair{Name: &name, Value: &value})
+		}
+		kept = append(kept, m)
+	}
+	mf.Metric = kept
+}
@@ -0,0 +1,273 @@
+package scrape
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// An exposition endpoint whose response can be changed between scrapes.
+type testEndpoint struct {
+	mu     sync.Mutex
+	status int
+	body   string
+}
+
+func (e *testEndpoint) set(status int, body string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.status, e.body = status, body
+}
+
+func (e *testEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(e.status)
+	fmt.Fprint(w, e.body)
+}
+
+func newTestServer(t *testing.T, body string) (*testEndpoint, *httptest.Server) {
+	e := &testEndpoint{status: http.StatusOK, body: body}
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	return e, srv
+}
+
+// Gathers the metrics exported by c through a registry, which fails on
+// duplicate series as /metrics would, and returns them as
+// "name{labels} value" strings, excluding the scrape meta-metrics.
+func gather(t *testing.T, c *Collector) []string {
+	t.Helper()
+	reg := promm.NewRegistry()
+	reg.MustRegister(c)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	var got []string
+	for _, mf := range mfs {
+		if strings.HasPrefix(mf.GetName(), namespace+"_"+subsystem+"_") {
+			continue
+		}
+		for _, m := range mf.Metric {
+			got = append(got, formatMetric(mf.GetName(), m))
+		}
+	}
+	sort.Strings(got)
+	return got
+}
+
+// Returns the value of the scrape meta-metric with the given name.
+func metaValue(t *testing.T, c *Collector, name string) float64 {
+	t.Helper()
+	reg := promm.NewRegistry()
+	reg.MustRegister(c)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == namespace+"_"+subsystem+"_"+name {
+			return mf.Metric[0].GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("meta-metric %q not found", name)
+	return 0
+}
+
+// Labels with empty values are omitted, as they are equivalent to absent
+// labels.
+func formatMetric(name string, m *dto.Metric) string {
+	var labels []string
+	for _, lp := range m.Label {
+		if lp.GetValue() == "" {
+			continue
+		}
+		labels = append(labels, fmt.Sprintf("%s=%q", lp.GetName(), lp.GetValue()))
+	}
+	var value float64
+	switch {
+	case m.Gauge != nil:
+		value = m.GetGauge().GetValue()
+	case m.Counter != nil:
+		value = m.GetCounter().GetValue()
+	case m.Untyped != nil:
+		value = m.GetUntyped().GetValue()
+	}
+	return fmt.Sprintf("%s{%s} %g", name, strings.Join(labels, ","), value)
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func checkMetrics(t *testing.T, got, want []string) {
+	t.Helper()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got metrics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestScrape(t *testing.T) {
+	_, srv := newTestServer(t, `# HELP temp_celsius Temperature.
+# TYPE temp_celsius gauge
+temp_celsius{room="hall"} 19.5
+temp_celsius{room="loft"} 12
+# TYPE requests_total counter
+requests_total 42
+`)
+	c, err := newCollector(Config{URL: srv.URL, Labels: promm.Labels{"site": "home"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.scrape()
+	checkMetrics(t, gather(t, c), []string{
+		`requests_total{site="home"} 42`,
+		`temp_celsius{room="hall",site="home"} 19.5`,
+		`temp_celsius{room="loft",site="home"} 12`,
+	})
+	if up := metaValue(t, c, "up"); up != 1 {
+		t.Errorf("up = %v, want 1", up)
+	}
+}
+
+func TestScrapeRelabel(t *testing.T) {
+	_, srv := newTestServer(t, `# TYPE temp_celsius gauge
+temp_celsius{id="28-01",room="hall"} 19.5
+temp_celsius{id="28-02",room="loft"} 12
+# TYPE humidity_ratio gauge
+humidity_ratio{id="a"} 0.5
+humidity_ratio{id="b"} 0.6
+# TYPE dropped gauge
+dropped 1
+`)
+	c, err := newCollector(Config{
+		URL: srv.URL,
+		Relabel: []RelabelConfig{
+			{Action: "drop", SourceLabel: nameLabel, Regex: "dropped"},
+			{SourceLabel: "room", Regex: "h(.*)", TargetLabel: "area", Replacement: stringPtr("H${1}")},
+			// An empty replacement removes the label.
+			{SourceLabel: "room", Regex: "loft", TargetLabel: "room", Replacement: stringPtr("")},
+			{Action: "labeldrop", Regex: "id"},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.scrape()
+	// Both humidity_ratio metrics have no labels once "id" is dropped, so the
+	// family cannot be exported.
+	checkMetrics(t, gather(t, c), []string{
+		`temp_celsius{area="Hall",room="hall"} 19.5`,
+		`temp_celsius{} 12`,
+	})
+}
+
+func TestRelabelReplacementDefault(t *testing.T) {
+	r, err := newRelabeler(RelabelConfig{SourceLabel: "id", Regex: "room-(.*)", TargetLabel: "room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{"id": "room-hall"}
+	r.apply(labels)
+	if got := labels["room"]; got != "hall" {
+		t.Errorf("room = %q, want %q", got, "hall")
+	}
+}
+
+func TestScrapeConflicts(t *testing.T) {
+	existing := promm.NewRegistry()
+	existing.MustRegister(
+		promm.NewGauge(promm.GaugeOpts{Name: "temp_celsius", Help: "Temperature."}),
+		promm.NewGauge(promm.GaugeOpts{Name: "other_help", Help: "Local help."}),
+	)
+	_, srv := newTestServer(t, `# HELP temp_celsius Temperature.
+# TYPE temp_celsius gauge
+temp_celsius 10
+temp_celsius{room="hall"} 19.5
+# HELP other_help Remote help.
+# TYPE other_help gauge
+other_help{room="hall"} 1
+# TYPE new_metric gauge
+new_metric 2
+`)
+	c, err := newCollector(Config{URL: srv.URL}, existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.scrape()
+	// temp_celsius{} is already exported (temp_celsius{room=""} is the same
+	// series), and other_help has different help, so both families are
+	// dropped.
+	checkMetrics(t, gather(t, c), []string{
+		`new_metric{} 2`,
+	})
+}
+
+func TestScrapeFailureCache(t *testing.T) {
+	body := "# TYPE temp_celsius gauge\ntemp_celsius 19.5\n"
+	for _, tc := range []struct {
+		name      string
+		cacheFor  time.Duration
+		want      []string
+		wantStale float64
+	}{
+		{"no cache", 0, nil, 0},
+		{"cached", time.Hour, []string{`temp_celsius{} 19.5`}, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			endpoint, srv := newTestServer(t, body)
+			c, err := newCollector(Config{
+				URL:      srv.URL,
+				CacheFor: util.Duration{Duration: tc.cacheFor},
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.scrape()
+			checkMetrics(t, gather(t, c), []string{`temp_celsius{} 19.5`})
+
+			endpoint.set(http.StatusInternalServerError, "")
+			c.scrape()
+			checkMetrics(t, gather(t, c), tc.want)
+			if up := metaValue(t, c, "up"); up != 0 {
+				t.Errorf("up = %v, want 0", up)
+			}
+			if stale := metaValue(t, c, "stale"); stale != tc.wantStale {
+				t.Errorf("stale = %v, want %v", stale, tc.wantStale)
+			}
+		})
+	}
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+	c, err := newCollector(Config{
+		URL:     srv.URL,
+		Timeout: util.Duration{Duration: 20 * time.Millisecond},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.scrape()
+	m := new(dto.Metric)
+	if err := c.timeouts.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetCounter().GetValue(); got != 1 {
+		t.Errorf("timeouts = %v, want 1", got)
+	}
+}
@@ -0,0 +1,189 @@
+// Package textfile exports metrics read from files in the Prometheus text
+// exposition format. These are typically written by cron jobs and other
+// short-lived programs that cannot be scraped directly.
+package textfile
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	namespace = "warren"
+	subsystem = "textfile"
+	// Files in Config.Directory that are read.
+	filePattern = "*.prom"
+)
+
+type Config struct {
+	// The directory to read "*.prom" files from. Writers should write to a
+	// temporary file and rename it into place, so that partially written files
+	// are not read.
+	Directory string
+	// How often to re-read the files in Directory. Defaults to 1 minute if
+	// unspecified.
+	Interval util.Duration
+	Labels   promm.Labels
+}
+
+// Collector implements prometheus.Collector for metrics read from Prometheus
+// text format files. The metrics are not known in advance, so Collector does
+// not describe them - this makes it an "unchecked" collector. Instead, metric
+// families are checked against those already exported via the given
+// prometheus.Gatherer, and conflicting families are dropped.
+type Collector struct {
+	cfg       Config
+	gatherer  promm.Gatherer
+	mtime     *promm.GaugeVec
+	readError *promm.GaugeVec
+
+	mu sync.Mutex
+	// Metrics converted from the files' metric families.
+	metrics []promm.Metric
+	// Series signatures of metrics.
+	series util.SeriesSet
+}
+
+func New(cfg Config, gatherer promm.Gatherer) (*Collector, error) {
+	if cfg.Directory == "" {
+		return nil, errors.New("missing Directory in textfile config")
+	}
+	if cfg.Interval.Duration == 0 {
+		cfg.Interval.Duration = time.Minute
+	}
+	c := &Collector{
+		cfg:      cfg,
+		gatherer: gatherer,
+		mtime: promm.NewGaugeVec(
+			promm.GaugeOpts{
+				Namespace: namespace, Subsystem: subsystem, Name: "mtime_seconds",
+				Help:        "Modification time of the file that metrics were read from. (seconds since epoch)",
+				ConstLabels: cfg.Labels,
+			},
+			[]string{"file"},
+		),
+		readError: promm.NewGaugeVec(
+			promm.GaugeOpts{
+				Namespace: namespace, Subsystem: subsystem, Name: "read_error",
+				Help:        "1 if the file could not be read or parsed when last read, 0 otherwise.",
+				ConstLabels: cfg.Labels,
+			},
+			[]string{"file"},
+		),
+	}
+	c.refresh()
+	go c.refreshLoop()
+	return c, nil
+}
+
+// Describe does not send any descriptors, see Collector.
+func (c *Collector) Describe(ch chan<- *promm.Desc) {}
+
+func (c *Collector) Collect(ch chan<- promm.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mtime.Collect(ch)
+	c.readError.Collect(ch)
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
+
+func (c *Collector) refreshLoop() {
+	for range time.Tick(c.cfg.Interval.Duration) {
+		c.refresh()
+	}
+}
+
+// refresh re-reads all files in the directory, replacing the previously read
+// metrics.
+func (c *Collector) refresh() {
+	c.mu.Lock()
+	own := c.series
+	c.mu.Unlock()
+	existing := util.GatherFamilies(c.gatherer, own)
+
+	paths, err := filepath.Glob(filepath.Join(c.cfg.Directory, filePattern))
+	if err != nil {
+		log.Printf("Error listing textfiles in %q: %v", c.cfg.Directory, err)
+		return
+	}
+	sort.Strings(paths)
+
+	mtimes := make(map[string]time.Time, len(paths))
+	readErrors := make(map[string]bool, len(paths))
+	var metrics []promm.Metric
+	series := util.SeriesSet{}
+	for _, path := range paths {
+		mtime, families, err := readFile(path)
+		// The mtime of a file that cannot be parsed is still exported, so that
+		// the file shows as stale rather than disappearing.
+		if !mtime.IsZero() {
+			mtimes[path] = mtime
+		}
+		readErrors[path] = err != nil
+		if err != nil {
+			log.Printf("Error reading textfile %q: %v", path, err)
+			continue
+		}
+		for name, mf := range families {
+			fm, err := util.FamilyMetrics(mf, c.cfg.Labels)
+			if err == nil {
+				err = existing.Add(mf, fm, series)
+			}
+			if err != nil {
+				log.Printf("Ignoring metric %q in textfile %q: %v", name, path, err)
+				continue
+			}
+			metrics = append(metrics, fm...)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metrics = metrics
+	c.series = series
+	c.mtime.Reset()
+	for path, mtime := range mtimes {
+		c.mtime.With(promm.Labels{"file": path}).Set(float64(mtime.UnixNano()) / 1e9)
+	}
+	c.readError.Reset()
+	for path, failed := range readErrors {
+		var v float64
+		if failed {
+			v = 1
+		}
+		c.readError.With(promm.Labels{"file": path}).Set(v)
+	}
+}
+
+// Returns the modification time of the file at path, and the metric families
+// parsed from it. The modification time is returned whenever the file could be
+// opened, even if it could not be parsed.
+func readFile(path string) (time.Time, map[string]*dto.MetricFamily, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(f)
+	if err != nil {
+		return stat.ModTime(), nil, err
+	}
+	return stat.ModTime(), families, nil
+}
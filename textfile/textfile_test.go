@@ -0,0 +1,185 @@
+package textfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/huin/warren/util"
+	promm "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Modification time given to test files.
+var testMtime = time.Unix(1500000000, 0)
+
+// Writes a textfile named name in dir, with the modification time testMtime.
+func writeFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, testMtime, testMtime); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Gathers the metrics exported by g, and returns them as sorted
+// "name{labels} value" strings.
+func gatherLines(t *testing.T, g promm.Gatherer) []string {
+	t.Helper()
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	var lines []string
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			lines = append(lines, formatMetric(mf.GetName(), m))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func formatMetric(name string, m *dto.Metric) string {
+	var labels []string
+	for _, lp := range m.Label {
+		labels = append(labels, fmt.Sprintf("%s=%q", lp.GetName(), lp.GetValue()))
+	}
+	var value float64
+	switch {
+	case m.Gauge != nil:
+		value = m.GetGauge().GetValue()
+	case m.Counter != nil:
+		value = m.GetCounter().GetValue()
+	case m.Untyped != nil:
+		value = m.GetUntyped().GetValue()
+	}
+	return fmt.Sprintf("%s{%s} %g", name, strings.Join(labels, ","), value)
+}
+
+func checkLines(t *testing.T, got, want []string) {
+	t.Helper()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got metrics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// Creates a Collector for dir, registered with a new registry, which is
+// returned.
+func newTestCollector(t *testing.T, dir string) (*Collector, *promm.Registry) {
+	t.Helper()
+	reg := promm.NewPedanticRegistry()
+	c, err := New(Config{Directory: dir, Labels: promm.Labels{"job": "cron"}}, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	reg.MustRegister(c)
+	return c, reg
+}
+
+func TestTextfile(t *testing.T) {
+	dir := t.TempDir()
+	backup := writeFile(t, dir, "backup.prom", `# HELP backup_last_success_seconds Time of the last successful backup.
+# TYPE backup_last_success_seconds gauge
+backup_last_success_seconds 1.5e+09
+# TYPE backup_runs_total counter
+backup_runs_total{host="nas"} 3
+`)
+	writeFile(t, dir, "ignored.txt", "ignored 1\n")
+	_, reg := newTestCollector(t, dir)
+	checkLines(t, gatherLines(t, reg), []string{
+		`backup_last_success_seconds{job="cron"} 1.5e+09`,
+		`backup_runs_total{host="nas",job="cron"} 3`,
+		fmt.Sprintf(`warren_textfile_mtime_seconds{file=%q,job="cron"} 1.5e+09`, backup),
+		fmt.Sprintf(`warren_textfile_read_error{file=%q,job="cron"} 0`, backup),
+	})
+}
+
+func TestTextfileParseError(t *testing.T) {
+	dir := t.TempDir()
+	good := writeFile(t, dir, "good.prom", "good 1\n")
+	broken := writeFile(t, dir, "broken.prom", "# TYPE broken gauge\nbroken{ 1\n")
+	_, reg := newTestCollector(t, dir)
+	// The broken file's metrics are missing, but its mtime is still exported,
+	// so that it shows as stale.
+	checkLines(t, gatherLines(t, reg), []string{
+		`good{job="cron"} 1`,
+		fmt.Sprintf(`warren_textfile_mtime_seconds{file=%q,job="cron"} 1.5e+09`, broken),
+		fmt.Sprintf(`warren_textfile_mtime_seconds{file=%q,job="cron"} 1.5e+09`, good),
+		fmt.Sprintf(`warren_textfile_read_error{file=%q,job="cron"} 1`, broken),
+		fmt.Sprintf(`warren_textfile_read_error{file=%q,job="cron"} 0`, good),
+	})
+}
+
+func TestTextfileRemoved(t *testing.T) {
+	dir := t.TempDir()
+	keep := writeFile(t, dir, "keep.prom", "keep 1\n")
+	remove := writeFile(t, dir, "remove.prom", "remove 2\n")
+	c, reg := newTestCollector(t, dir)
+	checkLines(t, gatherLines(t, reg), []string{
+		`keep{job="cron"} 1`,
+		`remove{job="cron"} 2`,
+		fmt.Sprintf(`warren_textfile_mtime_seconds{file=%q,job="cron"} 1.5e+09`, keep),
+		fmt.Sprintf(`warren_textfile_mtime_seconds{file=%q,job="cron"} 1.5e+09`, remove),
+		fmt.Sprintf(`warren_textfile_read_error{file=%q,job="cron"} 0`, keep),
+		fmt.Sprintf(`warren_textfile_read_error{file=%q,job="cron"} 0`, remove),
+	})
+
+	if err := os.Remove(remove); err != nil {
+		t.Fatal(err)
+	}
+	c.refresh()
+	checkLines(t, gatherLines(t, reg), []string{
+		`keep{job="cron"} 1`,
+		fmt.Sprintf(`warren_textfile_mtime_seconds{file=%q,job="cron"} 1.5e+09`, keep),
+		fmt.Sprintf(`warren_textfile_read_error{file=%q,job="cron"} 0`, keep),
+	})
+}
+
+func TestTextfileConflicts(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "conflicts.prom", `# HELP temp_celsius Temperature.
+# TYPE temp_celsius gauge
+temp_celsius{job="cron"} 10
+# TYPE up_count counter
+up_count 1
+# TYPE new_metric gauge
+new_metric 2
+`)
+	// Existing metrics are checked via a snapshot, as in warren.go.
+	reg := promm.NewPedanticRegistry()
+	reg.MustRegister(
+		promm.NewGauge(promm.GaugeOpts{Name: "temp_celsius", Help: "Temperature.", ConstLabels: promm.Labels{"job": "cron"}}),
+		promm.NewGauge(promm.GaugeOpts{Name: "up_count", Help: "Local help."}),
+	)
+	snapshot := util.NewSnapshotGatherer(reg)
+	c, err := New(Config{Directory: dir}, snapshot.Snapshot())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	reg.MustRegister(c)
+	// temp_celsius{job="cron"} is already exported, and up_count has a
+	// different type, so both families are dropped.
+	want := []string{
+		`new_metric{} 2`,
+		`temp_celsius{job="cron"} 0`,
+		`up_count{} 0`,
+		fmt.Sprintf(`warren_textfile_mtime_seconds{file=%q} 1.5e+09`, path),
+		fmt.Sprintf(`warren_textfile_read_error{file=%q} 0`, path),
+	}
+	checkLines(t, gatherLines(t, snapshot), want)
+
+	// The collector's own metrics in the snapshot do not conflict with
+	// themselves when the files are read again.
+	c.refresh()
+	checkLines(t, gatherLines(t, snapshot), want)
+}
@@ -0,0 +1,243 @@
+package util
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	promm "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// FamilyMetrics converts the metrics in mf to constant metrics, with
+// constLabels added to each. Metrics in the family that lack a label that
+// others have are given an empty value for it, which Prometheus treats as
+// equivalent.
+func FamilyMetrics(mf *dto.MetricFamily, constLabels promm.Labels) ([]promm.Metric, error) {
+	if len(mf.Metric) == 0 {
+		return nil, nil
+	}
+
+	labelNameSet := map[string]struct{}{}
+	for _, m := range mf.Metric {
+		for _, lp := range m.Label {
+			labelNameSet[lp.GetName()] = struct{}{}
+		}
+	}
+	labelNames := make([]string, 0, len(labelNameSet))
+	for ln := range labelNameSet {
+		labelNames = append(labelNames, ln)
+	}
+	sort.Strings(labelNames)
+	desc := promm.NewDesc(mf.GetName(), mf.GetHelp(), labelNames, constLabels)
+
+	metrics := make([]promm.Metric, 0, len(mf.Metric))
+	labels := make(map[string]string, len(labelNames))
+	for _, m := range mf.Metric {
+		for k := range labels {
+			delete(labels, k)
+		}
+		for _, lp := range m.Label {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		labelValues := make([]string, len(labelNames))
+		for i, ln := range labelNames {
+			labelValues[i] = labels[ln]
+		}
+
+		metric, err := constMetric(desc, mf.GetType(), m, labelValues)
+		if err != nil {
+			return nil, err
+		}
+		if m.TimestampMs != nil {
+			metric = promm.NewMetricWithTimestamp(time.Unix(0, m.GetTimestampMs()*int64(time.Millisecond)), metric)
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+func constMetric(desc *promm.Desc, mt dto.MetricType, m *dto.Metric, labelValues []string) (promm.Metric, error) {
+	switch mt {
+	case dto.MetricType_COUNTER:
+		return promm.NewConstMetric(desc, promm.CounterValue, m.GetCounter().GetValue(), labelValues...)
+	case dto.MetricType_GAUGE:
+		return promm.NewConstMetric(desc, promm.GaugeValue, m.GetGauge().GetValue(), labelValues...)
+	case dto.MetricType_UNTYPED:
+		return promm.NewConstMetric(desc, promm.UntypedValue, m.GetUntyped().GetValue(), labelValues...)
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		quantiles := make(map[float64]float64, len(s.Quantile))
+		for _, q := range s.Quantile {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+		return promm.NewConstSummary(desc, s.GetSampleCount(), s.GetSampleSum(), quantiles, labelValues...)
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		buckets := make(map[float64]uint64, len(h.Bucket))
+		for _, b := range h.Bucket {
+			// The +Inf bucket is implied by the sample count.
+			if !math.IsInf(b.GetUpperBound(), +1) {
+				buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+			}
+		}
+		return promm.NewConstHistogram(desc, h.GetSampleCount(), h.GetSampleSum(), buckets, labelValues...)
+	default:
+		return nil, fmt.Errorf("unhandled metric type %v", mt)
+	}
+}
+
+// SeriesSet is a set of series signatures, see Families.
+type SeriesSet map[string]struct{}
+
+// Families holds the metric families exported by a prometheus.Gatherer, so
+// that metrics from elsewhere can be checked for conflicts before being
+// exported alongside them.
+type Families struct {
+	byName map[string]*dto.MetricFamily
+	series SeriesSet
+}
+
+// SnapshotGatherer is a prometheus.Gatherer that keeps the families from each
+// Gather, so that they can be checked against (e.g by GatherFamilies) without
+// gathering again. Gathering calls every collector's Collect, which can have
+// side effects (e.g running commands, or counting operations), so this should
+// only be done when serving a scrape.
+type SnapshotGatherer struct {
+	gatherer promm.Gatherer
+
+	mu       sync.Mutex
+	families []*dto.MetricFamily
+	gathered bool
+}
+
+func NewSnapshotGatherer(g promm.Gatherer) *SnapshotGatherer {
+	return &SnapshotGatherer{gatherer: g}
+}
+
+// Gather gathers from the underlying Gatherer, keeping the result (including
+// any partial result in the event of an error) as the snapshot.
+func (s *SnapshotGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := s.gatherer.Gather()
+	s.mu.Lock()
+	s.families = mfs
+	s.gathered = true
+	s.mu.Unlock()
+	return mfs, err
+}
+
+// Snapshot returns a Gatherer that returns the families from the most recent
+// call to Gather, which must not be modified. If Gather has not yet been
+// called, then it is called once to take the first snapshot.
+func (s *SnapshotGatherer) Snapshot() promm.Gatherer {
+	return promm.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		s.mu.Lock()
+		gathered, mfs := s.gathered, s.families
+		s.mu.Unlock()
+		if !gathered {
+			return s.Gather()
+		}
+		return mfs, nil
+	})
+}
+
+// GatherFamilies gathers the metric families currently exported by g, omitting
+// the series in own. g may be nil, in which case nothing conflicts.
+func GatherFamilies(g promm.Gatherer, own SeriesSet) *Families {
+	f := &Families{
+		byName: map[string]*dto.MetricFamily{},
+		series: SeriesSet{},
+	}
+	if g == nil {
+		return f
+	}
+	// Gather returns whatever it could in the event of an error, so use that
+	// regardless.
+	mfs, err := g.Gather()
+	if err != nil {
+		log.Printf("Error gathering existing metrics to check against: %v", err)
+	}
+	for _, mf := range mfs {
+		var metrics []*dto.Metric
+		for _, m := range mf.Metric {
+			sig := seriesSignature(mf.GetName(), m.Label)
+			if _, ok := own[sig]; ok {
+				continue
+			}
+			f.series[sig] = struct{}{}
+			metrics = append(metrics, m)
+		}
+		if len(metrics) > 0 {
+			f.byName[mf.GetName()] = &dto.MetricFamily{
+				Name: mf.Name, Help: mf.Help, Type: mf.Type, Metric: metrics,
+			}
+		}
+	}
+	return f
+}
+
+// Add checks that metrics, converted from mf by FamilyMetrics, can be exported
+// alongside the existing families. If so, they are added to f, and their
+// series signatures added to own. Metrics may have different label names to
+// existing metrics of the same name (e.g an additional "instance" label), but
+// must have the same type and help. No two metrics may have the same series,
+// which can happen if they were relabeled.
+func (f *Families) Add(mf *dto.MetricFamily, metrics []promm.Metric, own SeriesSet) error {
+	name := mf.GetName()
+	existing := f.byName[name]
+	if existing != nil {
+		if existing.GetType() != mf.GetType() {
+			return fmt.Errorf("type %v differs from existing %v", mf.GetType(), existing.GetType())
+		}
+		if existing.GetHelp() != mf.GetHelp() {
+			return fmt.Errorf("help %q differs from existing %q", mf.GetHelp(), existing.GetHelp())
+		}
+	}
+
+	written := make([]*dto.Metric, 0, len(metrics))
+	sigs := make(SeriesSet, len(metrics))
+	for _, metric := range metrics {
+		m := new(dto.Metric)
+		if err := metric.Write(m); err != nil {
+			return err
+		}
+		sig := seriesSignature(name, m.Label)
+		if _, ok := f.series[sig]; ok {
+			return fmt.Errorf("duplicate series %s", sig)
+		}
+		if _, ok := sigs[sig]; ok {
+			return fmt.Errorf("duplicate series %s within family", sig)
+		}
+		written = append(written, m)
+		sigs[sig] = struct{}{}
+	}
+
+	if existing == nil {
+		existing = &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+		f.byName[name] = existing
+	}
+	existing.Metric = append(existing.Metric, written...)
+	for sig := range sigs {
+		f.series[sig] = struct{}{}
+		own[sig] = struct{}{}
+	}
+	return nil
+}
+
+// Returns a signature that identifies a series. Labels with empty values are
+// omitted, as Prometheus treats them as equivalent to the label being absent.
+func seriesSignature(name string, lps []*dto.LabelPair) string {
+	pairs := make([]string, 0, len(lps))
+	for _, lp := range lps {
+		if lp.GetValue() == "" {
+			continue
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%q", lp.GetName(), lp.GetValue()))
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
@@ -1,6 +1,11 @@
 package util
 
-import promm "github.com/prometheus/client_golang/prometheus"
+import (
+	"strings"
+	"sync"
+
+	promm "github.com/prometheus/client_golang/prometheus"
+)
 
 type MetricCollection []promm.Collector
 
@@ -38,6 +43,12 @@ func (mc *MetricCollection) NewHistogramVec(opts promm.HistogramOpts, labelNames
 	return c
 }
 
+func (mc *MetricCollection) NewValueVec(opts promm.Opts, valueType promm.ValueType, labelNames []string) *ValueVec {
+	c := NewValueVec(opts, valueType, labelNames)
+	mc.Add(c)
+	return c
+}
+
 func (mc MetricCollection) Describe(ch chan<- *promm.Desc) {
 	for _, c := range mc {
 		c.Describe(ch)
@@ -49,3 +60,82 @@ func (mc MetricCollection) Collect(ch chan<- promm.Metric) {
 		c.Collect(ch)
 	}
 }
+
+// ValueVec is a vector of metrics of any value type whose values are set
+// directly. It is intended for exporting counters that are maintained
+// elsewhere (e.g by the kernel), which promm.Counter cannot be set to.
+type ValueVec struct {
+	desc       *promm.Desc
+	valueType  promm.ValueType
+	labelNames []string
+
+	mu sync.Mutex
+	// Values by label values, joined with labelValueSep.
+	values map[string]float64
+}
+
+// Separates label values in ValueVec keys. Not valid in UTF-8, so cannot
+// appear in a label value.
+const labelValueSep = "\xff"
+
+func NewValueVec(opts promm.Opts, valueType promm.ValueType, labelNames []string) *ValueVec {
+	return &ValueVec{
+		desc: promm.NewDesc(
+			promm.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+			opts.Help, labelNames, opts.ConstLabels),
+		valueType:  valueType,
+		labelNames: labelNames,
+		values:     make(map[string]float64),
+	}
+}
+
+// Set sets the value of the metric with the given labels, which must have
+// exactly the vector's label names.
+func (v *ValueVec) Set(labels promm.Labels, value float64) {
+	key := v.key(labels)
+	v.mu.Lock()
+	v.values[key] = value
+	v.mu.Unlock()
+}
+
+// Delete removes the metric with the given labels, returning true if it was
+// present.
+func (v *ValueVec) Delete(labels promm.Labels) bool {
+	key := v.key(labels)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	_, ok := v.values[key]
+	delete(v.values, key)
+	return ok
+}
+
+func (v *ValueVec) key(labels promm.Labels) string {
+	labelValues := make([]string, len(v.labelNames))
+	for i, ln := range v.labelNames {
+		labelValues[i] = labels[ln]
+	}
+	return strings.Join(labelValues, labelValueSep)
+}
+
+// Reset removes all metrics from the vector.
+func (v *ValueVec) Reset() {
+	v.mu.Lock()
+	v.values = make(map[string]float64)
+	v.mu.Unlock()
+}
+
+func (v *ValueVec) Describe(ch chan<- *promm.Desc) {
+	ch <- v.desc
+}
+
+func (v *ValueVec) Collect(ch chan<- promm.Metric) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for key, value := range v.values {
+		var labelValues []string
+		if len(v.labelNames) > 0 {
+			labelValues = strings.Split(key, labelValueSep)
+		}
+		ch <- promm.MustNewConstMetric(v.desc, v.valueType, value, labelValues...)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"syscall"
@@ -15,11 +16,16 @@ import (
 
 	"github.com/BurntSushi/toml"
 	"github.com/huin/warren/cc"
+	"github.com/huin/warren/federate"
 	"github.com/huin/warren/httpexport"
 	"github.com/huin/warren/linux"
+	"github.com/huin/warren/scrape"
 	"github.com/huin/warren/streammatch"
 	"github.com/huin/warren/systemd"
+	"github.com/huin/warren/textfile"
+	"github.com/huin/warren/util"
 	promm "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
@@ -31,13 +37,25 @@ type Config struct {
 	// (unknown keys will be an error in future, for now they are simply logged)
 	IgnoreUnknownKeys bool `toml:"ignore_unknown_keys"`
 	LogPath           string
-	Prometheus        PrometheusConfig
-	CurrentCost       []cc.Config
-	File              []streammatch.FileCfg
-	Proc              []streammatch.ProcCfg
-	System            *linux.Config
-	Systemd           *systemd.Config
-	HTTPExport        []httpexport.Config
+	// User and Group to switch to once devices, log files and listening sockets
+	// have been opened. Empty values leave the user/group unchanged. If only User
+	// is set, then the user's primary group is used.
+	User  string
+	Group string
+	// Set the no_new_privs process flag once privileges have been dropped, so
+	// that neither warren nor its child processes can regain them (e.g via
+	// setuid binaries). Requires warren to be built with CGO_ENABLED=0.
+	NoNewPrivs  bool `toml:"no_new_privs"`
+	Prometheus  PrometheusConfig
+	CurrentCost []cc.Config
+	File        []streammatch.FileCfg
+	Proc        []streammatch.ProcCfg
+	System      *linux.Config
+	Systemd     *systemd.Config
+	HTTPExport  []httpexport.Config
+	TextFile    []textfile.Config
+	Scrape      []scrape.Config
+	Federate    *federate.Config
 }
 
 type PrometheusConfig struct {
@@ -69,7 +87,7 @@ func readConfig(filename string) (*Config, error) {
 	}
 	keys := md.Undecoded()
 	if !config.IgnoreUnknownKeys && len(keys) > 0 {
-		log.Printf("Found %d unknown keys in configuration file %q. This will be a fatal error in future, set `ignore_unknown_keys = true` to prevent this message or errors.", len(keys))
+		log.Printf("Found %d unknown keys in configuration file %q. This will be a fatal error in future, set `ignore_unknown_keys = true` to prevent this message or errors.", len(keys), filename)
 		for _, key := range keys {
 			log.Printf("Unknown key: %q", key)
 		}
@@ -113,17 +131,37 @@ func main() {
 		log.Fatal("Failed to read configuration: ", err)
 	}
 	initLogging(config.LogPath)
+	if config.NoNewPrivs {
+		// Fail before opening anything, rather than once privileges are due to be
+		// dropped.
+		if err := checkNoNewPrivs(); err != nil {
+			log.Fatal("Cannot set no_new_privs: ", err)
+		}
+	}
+
+	// Listen before dropping privileges, in case the address is a privileged
+	// port.
+	listener, err := net.Listen("tcp", config.Prometheus.ServeAddr)
+	if err != nil {
+		log.Fatal("Failed to listen for Prometheus: ", err)
+	}
 
 	if len(config.CurrentCost) > 0 {
 		log.Printf("Starting %d CurrentCost collectors", len(config.CurrentCost))
 	}
+	ccCollectors := make([]*cc.Collector, 0, len(config.CurrentCost))
 	for i, cfg := range config.CurrentCost {
 		c, err := cc.New(cfg)
 		if err != nil {
 			log.Fatalf("Error in CurrentCost[%d]: %v", i, err)
 		}
+		// Open the device while still privileged. Run falls back to opening the
+		// device itself if this fails.
+		if err := c.Open(); err != nil {
+			log.Printf("Could not open device for CurrentCost[%d] (will retry): %v", i, err)
+		}
 		promm.MustRegister(c)
-		go monitorLoop("currentcost", c.Run)
+		ccCollectors = append(ccCollectors, c)
 	}
 
 	if len(config.File) > 0 {
@@ -137,17 +175,6 @@ func main() {
 		promm.MustRegister(fc)
 	}
 
-	if len(config.Proc) > 0 {
-		log.Printf("Starting %d Proc collectors", len(config.Proc))
-	}
-	for i, cfg := range config.Proc {
-		c, err := streammatch.NewProcCollector(cfg)
-		if err != nil {
-			log.Fatalf("Error in Proc[%d]: %v", i, err)
-		}
-		promm.MustRegister(c)
-	}
-
 	if config.System != nil {
 		log.Print("Starting local system monitoring")
 		c, err := linux.New(*config.System)
@@ -177,7 +204,67 @@ func main() {
 		promm.MustRegister(c)
 	}
 
+	if err := dropPrivileges(config.User, config.Group, config.NoNewPrivs); err != nil {
+		log.Fatal("Failed to drop privileges: ", err)
+	}
+
+	// Anything that might open files or devices by name from here on does so
+	// without privileges.
+	for _, c := range ccCollectors {
+		go monitorLoop("currentcost", c.Run)
+	}
+
+	// Child processes are started after dropping privileges so that they do not
+	// run privileged.
+	if len(config.Proc) > 0 {
+		log.Printf("Starting %d Proc collectors", len(config.Proc))
+	}
+	for i, cfg := range config.Proc {
+		c, err := streammatch.NewProcCollector(cfg)
+		if err != nil {
+			log.Fatalf("Error in Proc[%d]: %v", i, err)
+		}
+		promm.MustRegister(c)
+	}
+
+	// TextFile, Scrape and Federate collectors check their metrics against those
+	// exported by all other collectors, so must be registered last. They check
+	// against the metrics from the most recent scrape, so that they do not cause
+	// extra calls to other collectors.
+	gatherer := util.NewSnapshotGatherer(promm.DefaultGatherer)
+	if len(config.TextFile) > 0 {
+		log.Printf("Starting %d TextFile collectors", len(config.TextFile))
+	}
+	for i, cfg := range config.TextFile {
+		c, err := textfile.New(cfg, gatherer.Snapshot())
+		if err != nil {
+			log.Fatalf("Error in TextFile[%d]: %v", i, err)
+		}
+		promm.MustRegister(c)
+	}
+
+	if len(config.Scrape) > 0 {
+		log.Printf("Starting %d Scrape collectors", len(config.Scrape))
+	}
+	for i, cfg := range config.Scrape {
+		c, err := scrape.New(cfg, gatherer.Snapshot())
+		if err != nil {
+			log.Fatalf("Error in Scrape[%d]: %v", i, err)
+		}
+		promm.MustRegister(c)
+	}
+
+	if config.Federate != nil {
+		log.Printf("Starting federation of %d peers", len(config.Federate.Peer))
+		c, err := federate.New(*config.Federate, gatherer.Snapshot())
+		if err != nil {
+			log.Fatalf("Error in Federate: %v", err)
+		}
+		promm.MustRegister(c)
+	}
+
 	log.Print("Starting Prometheus metrics handler")
-	http.Handle(config.Prometheus.HandlerPath, promm.Handler())
-	http.ListenAndServe(config.Prometheus.ServeAddr, nil)
+	http.Handle(config.Prometheus.HandlerPath,
+		promm.InstrumentHandler("prometheus", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})))
+	http.Serve(listener, nil)
 }